@@ -0,0 +1,87 @@
+// Command zlog works on log files written by zlog sinks.
+//
+//	zlog erase -file /var/log/app/access.log -field user_id -value 42
+//
+// removes every entry whose user_id is 42 from the file and its rotated
+// backups and prints an erasure report as JSON.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Pastir/zlog/zlog"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "erase":
+		err = erase(os.Args[2:])
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "zlog: unknown command %q\n", os.Args[1])
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "zlog:", err)
+		os.Exit(1)
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: zlog erase -file PATH -field NAME -value VALUE [-dry-run] [-local-time] [-time-key NAME] [-report FILE]")
+}
+
+func erase(args []string) error {
+	fs := flag.NewFlagSet("erase", flag.ExitOnError)
+	var opts zlog.EraseOptions
+	fs.StringVar(&opts.Path, "file", "", "active log file; rotated backups next to it are included")
+	fs.StringVar(&opts.Field, "field", "", "top-level field to match")
+	fs.StringVar(&opts.Value, "value", "", "value of the field to erase")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "count matching entries without rewriting files")
+	fs.BoolVar(&opts.LocalTime, "local-time", false, "backup names use local time (LocalTimeBackups)")
+	report := fs.String("report", "", "write the report to this file instead of stdout")
+	fs.StringVar(&opts.TimeKey, "time-key", "ts", "field holding entry times, for the manifest")
+	_ = fs.Parse(args)
+	if opts.Path == "" || opts.Field == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if opts.Value == "" {
+		// most likely a missing argument, not a request to erase empty fields
+		fmt.Fprintln(os.Stderr, "zlog erase: -value must not be empty")
+		os.Exit(2)
+	}
+
+	rep, err := zlog.Erase(opts)
+	if rep != nil {
+		if werr := writeReport(*report, rep); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+func writeReport(path string, rep *zlog.ErasureReport) error {
+	out, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	out = append(out, '\n')
+	if path == "" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return os.WriteFile(path, out, 0o600)
+}
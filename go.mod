@@ -3,8 +3,8 @@ module github.com/Pastir/zlog
 go 1.24
 
 require (
+	github.com/spf13/pflag v1.0.10
 	go.uber.org/zap v1.27.0
-	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 )
 
 require go.uber.org/multierr v1.10.0 // indirect
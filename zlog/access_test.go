@@ -0,0 +1,114 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func benchPair(tb testing.TB, w io.Writer, opts ...Option) *Pair {
+	tb.Helper()
+	p, err := New(append([]Option{WithAccessConsole(w, zapcore.DebugLevel, zapcore.FatalLevel)}, opts...)...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { p.Close() })
+	return p
+}
+
+func testRecord() *AccessRecord {
+	return &AccessRecord{
+		Method:    "GET",
+		Path:      "/api/v1/users",
+		Status:    200,
+		Bytes:     1234,
+		Duration:  3 * time.Millisecond,
+		IP:        "203.0.113.7",
+		UserAgent: "curl/8.0",
+	}
+}
+
+func TestLogAccessFields(t *testing.T) {
+	var buf bytes.Buffer
+	p := benchPair(t, &buf)
+	rec := testRecord()
+	rec.Extra = []zap.Field{zap.String("tenant", "acme")}
+	p.LogAccess(rec)
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"level": "INFO", "msg": "access", "method": "GET", "path": "/api/v1/users",
+		"status": 200.0, "bytes": 1234.0, "duration": 0.003, "remote_ip": "203.0.113.7",
+		"user_agent": "curl/8.0", "tenant": "acme",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestLogAccessAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable under the race detector")
+	}
+	for name, opts := range map[string][]Option{
+		"plain":      nil,
+		"sequence":   {WithSequence(false)},
+		"processors": {WithAccessProcessors(func(r *Record) bool { r.Rename("tenant", "org"); return true })},
+		"sink processors": {WithAccessFile(t.TempDir()+"/access.log", 1, 0, 0, false,
+			Processors(func(r *Record) bool { return true }))},
+		"ip anonymizer":   {WithIPAnonymization(IPAnonymizer{})},
+		"fields and with": {WithFields(zap.String("service", "api"))},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := benchPair(t, io.Discard, opts...)
+			rec := testRecord()
+			rec.Extra = []zap.Field{zap.String("tenant", "acme")}
+			want := 0.0
+			if name == "ip anonymizer" {
+				// the anonymized address is a new string
+				want = 1
+			}
+			if n := testing.AllocsPerRun(100, func() { p.LogAccess(rec) }); n != want {
+				t.Errorf("LogAccess allocates %v times per call, want %v", n, want)
+			}
+		})
+	}
+}
+
+func BenchmarkLogAccess(b *testing.B) {
+	p := benchPair(b, io.Discard)
+	rec := testRecord()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.LogAccess(rec)
+	}
+}
+
+func BenchmarkAccessInfo(b *testing.B) {
+	p := benchPair(b, io.Discard)
+	rec := testRecord()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Access.Info("access",
+			zap.String("method", rec.Method),
+			zap.String("path", rec.Path),
+			zap.Int("status", rec.Status),
+			zap.Int64("bytes", rec.Bytes),
+			zap.Duration("duration", rec.Duration),
+			zap.String("remote_ip", rec.IP),
+			zap.String("user_agent", rec.UserAgent),
+		)
+	}
+}
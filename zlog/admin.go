@@ -0,0 +1,110 @@
+package zlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+type levelsPayload struct {
+	Access string            `json:"access"`
+	Error  string            `json:"error"`
+	Sinks  map[string]string `json:"sinks"`
+}
+
+// AdminHandler serves the pair's runtime levels over HTTP:
+//
+//	GET /levels              all logger and sink levels as JSON
+//	GET /levels?name=<name>  one level, as zap.AtomicLevel serves it
+//	PUT /levels?name=<name>  change it with {"level":"debug"}
+//	GET /filters             the filter rules with their counters
+//	PUT /filters             replace them with a JSON array of FilterRule
+//
+// name is "access", "error" or a key of SinkLevels. Mount it under a prefix
+// with http.StripPrefix.
+func (p *Pair) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/levels", p.serveLevels)
+	mux.HandleFunc("/filters", p.serveFilters)
+	return mux
+}
+
+func (p *Pair) serveLevels(w http.ResponseWriter, r *http.Request) {
+	if name := r.URL.Query().Get("name"); name != "" {
+		lvl, ok := p.level(name)
+		if !ok {
+			http.Error(w, "unknown logger or sink: "+name, http.StatusNotFound)
+			return
+		}
+		lvl.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "name is required to change a level", http.StatusMethodNotAllowed)
+		return
+	}
+
+	out := levelsPayload{
+		Access: p.AccessLevel.String(),
+		Error:  p.ErrorLevel.String(),
+		Sinks:  make(map[string]string, len(p.SinkLevels)),
+	}
+	for name, lvl := range p.SinkLevels {
+		out.Sinks[name] = lvl.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func (p *Pair) serveFilters(w http.ResponseWriter, r *http.Request) {
+	if p.Filters == nil {
+		http.Error(w, "filters are not available", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var rules []FilterRule
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, "bad filter rules: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.Filters.Set(rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "only GET and PUT are supported", http.StatusMethodNotAllowed)
+		return
+	}
+	out := p.Filters.Rules()
+	if out == nil {
+		out = []FilterStatus{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// level finds a logger or sink level by name
+func (p *Pair) level(name string) (zap.AtomicLevel, bool) {
+	switch name {
+	case "access":
+		return p.AccessLevel, true
+	case "error":
+		return p.ErrorLevel, true
+	}
+	lvl, ok := p.SinkLevels[name]
+	return lvl, ok
+}
+
+// SinkNames returns the names of all sinks, sorted
+func (p *Pair) SinkNames() []string {
+	names := make([]string, 0, len(p.SinkLevels))
+	for name := range p.SinkLevels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,133 @@
+package zlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// adminRequest serves one request with h and returns the response
+func adminRequest(t *testing.T, h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminLevels(t *testing.T) {
+	p, err := New(WithAccessFile(filepath.Join(t.TempDir(), "access.log"), 1, 0, 0, false, SinkName("audit")),
+		WithInitialLevels(zapcore.DebugLevel, zapcore.DebugLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	h := p.AdminHandler()
+
+	w := adminRequest(t, h, "GET", "/levels", "")
+	var all levelsPayload
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /levels: %d %s", w.Code, w.Body)
+	}
+	if all.Access != "debug" || all.Error != "debug" || all.Sinks["audit"] != "debug" {
+		t.Fatalf("levels %+v", all)
+	}
+
+	for _, name := range []string{"access", "error", "audit"} {
+		w = adminRequest(t, h, "PUT", "/levels?name="+name, `{"level":"warn"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: %d %s", name, w.Code, w.Body)
+		}
+		w = adminRequest(t, h, "GET", "/levels?name="+name, "")
+		if !strings.Contains(w.Body.String(), `"warn"`) {
+			t.Fatalf("GET %s after PUT: %s", name, w.Body)
+		}
+	}
+	if p.AccessLevel.Level() != zapcore.WarnLevel || p.ErrorLevel.Level() != zapcore.WarnLevel || p.SinkLevels["audit"].Level() != zapcore.WarnLevel {
+		t.Fatal("PUT did not change the levels")
+	}
+}
+
+func TestAdminLevelsBadRequests(t *testing.T) {
+	p, err := New(WithInitialLevels(zapcore.DebugLevel, zapcore.DebugLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	h := p.AdminHandler()
+	for _, tc := range []struct {
+		method, target, body string
+		code                 int
+	}{
+		{"GET", "/levels?name=nope", "", http.StatusNotFound},
+		{"PUT", "/levels?name=nope", `{"level":"warn"}`, http.StatusNotFound},
+		{"PUT", "/levels", `{"level":"warn"}`, http.StatusMethodNotAllowed},
+		{"POST", "/levels", "", http.StatusMethodNotAllowed},
+		{"PUT", "/levels?name=access", `{"level":"loud"}`, http.StatusBadRequest},
+		{"PUT", "/levels?name=access", `{`, http.StatusBadRequest},
+		{"DELETE", "/levels?name=access", "", http.StatusMethodNotAllowed},
+	} {
+		if w := adminRequest(t, h, tc.method, tc.target, tc.body); w.Code != tc.code {
+			t.Errorf("%s %s %s: %d %s, want %d", tc.method, tc.target, tc.body, w.Code, w.Body, tc.code)
+		}
+	}
+	if p.AccessLevel.Level() != zapcore.DebugLevel {
+		t.Fatalf("access level changed to %v", p.AccessLevel.Level())
+	}
+}
+
+func TestAdminFilters(t *testing.T) {
+	p, rec := Test(t)
+	h := p.AdminHandler()
+
+	w := adminRequest(t, h, "GET", "/filters", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("GET /filters without rules: %d %s", w.Code, w.Body)
+	}
+
+	w = adminRequest(t, h, "PUT", "/filters", `[{"name":"healthz","expr":"path startsWith \"/healthz\""}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /filters: %d %s", w.Code, w.Body)
+	}
+	p.Access.Info("probe", zap.String("path", "/healthz"))
+	p.Access.Info("request", zap.String("path", "/users"))
+	if a := rec.Access(); len(a) != 1 || a[0].Message != "request" {
+		t.Fatalf("access entries %v", a)
+	}
+
+	w = adminRequest(t, h, "GET", "/filters", "")
+	var rules []FilterStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &rules); err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Name != "healthz" || rules[0].Matched != 1 {
+		t.Fatalf("rules %+v", rules)
+	}
+
+	for _, tc := range []struct {
+		method, body string
+		code         int
+	}{
+		{"PUT", `{"expr":"x"}`, http.StatusBadRequest},
+		{"PUT", `[{"expr":"("}]`, http.StatusBadRequest},
+		{"POST", `[]`, http.StatusMethodNotAllowed},
+		{"DELETE", "", http.StatusMethodNotAllowed},
+	} {
+		if w := adminRequest(t, h, tc.method, "/filters", tc.body); w.Code != tc.code {
+			t.Errorf("%s /filters %s: %d %s, want %d", tc.method, tc.body, w.Code, w.Body, tc.code)
+		}
+	}
+	// failed requests left the rules in place
+	if r := p.Filters.Rules(); len(r) != 1 || r[0].Name != "healthz" {
+		t.Fatalf("rules after bad requests %+v", r)
+	}
+}
@@ -0,0 +1,312 @@
+package zlog
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// IPAnonymizer configures WithIPAnonymization. Addresses are either
+	// truncated to a network prefix or, with Pseudonymize, replaced by a keyed
+	// hash that is stable within a UTC day and changes the next day.
+	IPAnonymizer struct {
+		// Fields to rewrite; defaults to remote_ip and x_forwarded_for.
+		// Values may be a single address, "addr:port", or a comma-separated
+		// list as in X-Forwarded-For.
+		Fields []string
+		// IPv4Prefix and IPv6Prefix are the prefix lengths kept when
+		// truncating; default 24 and 48
+		IPv4Prefix int
+		IPv6Prefix int
+
+		// Pseudonymize replaces addresses with "ip-<hex>" instead of truncating
+		Pseudonymize bool
+		// Secret derives the daily pseudonym keys. When empty a random secret is
+		// generated, so pseudonyms also change when the process restarts.
+		Secret []byte
+	}
+
+	// anonObject marshals an object through anonEncoder. Wrappers taken from
+	// an entryBuf reuse enc; the others are shared by the cores of a With
+	// logger and allocate an encoder per call.
+	anonObject struct {
+		a   *ipAnonymizer
+		m   zapcore.ObjectMarshaler
+		buf *entryBuf
+		enc anonEncoder
+	}
+
+	// anonArray marshals an array through anonArrayEncoder
+	anonArray struct {
+		a   *ipAnonymizer
+		m   zapcore.ArrayMarshaler
+		key string
+		buf *entryBuf
+	}
+
+	// anonEncoder anonymizes the configured string fields added to it and
+	// wraps the objects and arrays nested in it
+	anonEncoder struct {
+		zapcore.ObjectEncoder
+		a   *ipAnonymizer
+		buf *entryBuf
+	}
+
+	// anonArrayEncoder anonymizes the strings of an array held by a configured
+	// field and wraps the objects and arrays nested in it
+	anonArrayEncoder struct {
+		zapcore.ArrayEncoder
+		a   *ipAnonymizer
+		key string
+		buf *entryBuf
+	}
+
+	ipAnonymizer struct {
+		cfg    IPAnonymizer
+		fields map[string]bool
+
+		mu     sync.Mutex
+		day    string
+		dayKey []byte
+	}
+)
+
+var defaultIPFields = []string{"remote_ip", "x_forwarded_for"}
+
+func newIPAnonymizer(cfg IPAnonymizer) *ipAnonymizer {
+	if len(cfg.Fields) == 0 {
+		cfg.Fields = defaultIPFields
+	}
+	if cfg.IPv4Prefix <= 0 || cfg.IPv4Prefix > 32 {
+		cfg.IPv4Prefix = 24
+	}
+	if cfg.IPv6Prefix <= 0 || cfg.IPv6Prefix > 128 {
+		cfg.IPv6Prefix = 48
+	}
+	if len(cfg.Secret) == 0 {
+		cfg.Secret = make([]byte, 32)
+		_, _ = rand.Read(cfg.Secret)
+	}
+	a := &ipAnonymizer{cfg: cfg, fields: map[string]bool{}}
+	for _, f := range cfg.Fields {
+		a.fields[f] = true
+	}
+	return a
+}
+
+// transform rewrites the configured fields holding strings, byte strings or
+// Stringers, and wraps objects and arrays so that the fields nested in them,
+// such as the IP of an AccessRecord logged with Pair.LogAccess, are rewritten
+// when they are encoded. Sensitive fields are left alone; a Lazy field stays
+// lazy, its value is rewritten when it is encoded.
+func (a *ipAnonymizer) transform(buf *entryBuf, fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		var repl zapcore.Field
+		switch f.Type {
+		case zapcore.StringType, zapcore.ByteStringType, zapcore.StringerType:
+			if !a.fields[f.Key] {
+				continue
+			}
+			v, ok := stringValue(f)
+			if !ok {
+				continue
+			}
+			repl = zap.String(f.Key, a.anonymizeList(v))
+		case zapcore.InlineMarshalerType, zapcore.ObjectMarshalerType:
+			m, ok := f.Interface.(zapcore.ObjectMarshaler)
+			if !ok {
+				continue
+			}
+			if _, ok := m.(*sensitiveValue); ok {
+				// left for the sinks to render: wrapped, they would no
+				// longer recognize it
+				continue
+			}
+			repl = f
+			repl.Interface = buf.anonObject(a, m)
+		case zapcore.ArrayMarshalerType:
+			m, ok := f.Interface.(zapcore.ArrayMarshaler)
+			if !ok {
+				continue
+			}
+			repl = zap.Array(f.Key, &anonArray{a: a, m: m, key: f.Key, buf: buf})
+		default:
+			continue
+		}
+		if out == nil {
+			out = buf.own(fields)
+		}
+		out[i] = repl
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+// stringValue returns the value of a string, byte string or Stringer field.
+// A Stringer that panics is left to zap, which reports the panic.
+func stringValue(f zapcore.Field) (s string, ok bool) {
+	switch f.Type {
+	case zapcore.StringType:
+		return f.String, true
+	case zapcore.ByteStringType:
+		b, ok := f.Interface.([]byte)
+		return string(b), ok
+	}
+	st, ok := f.Interface.(fmt.Stringer)
+	if !ok {
+		return "", false
+	}
+	defer func() {
+		if recover() != nil {
+			s, ok = "", false
+		}
+	}()
+	return st.String(), true
+}
+
+// anonObject returns a wrapper anonymizing m, reused from b when b is set
+func (b *entryBuf) anonObject(a *ipAnonymizer, m zapcore.ObjectMarshaler) *anonObject {
+	if b == nil {
+		return &anonObject{a: a, m: m}
+	}
+	if b.nanon == len(b.anon) {
+		b.anon = append(b.anon, new(anonObject))
+	}
+	o := b.anon[b.nanon]
+	b.nanon++
+	o.a, o.m, o.buf = a, m, b
+	return o
+}
+
+func (o *anonObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if o.buf == nil {
+		return o.m.MarshalLogObject(&anonEncoder{ObjectEncoder: enc, a: o.a})
+	}
+	o.enc = anonEncoder{ObjectEncoder: enc, a: o.a, buf: o.buf}
+	return o.m.MarshalLogObject(&o.enc)
+}
+
+func (o *anonArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	return o.m.MarshalLogArray(&anonArrayEncoder{ArrayEncoder: enc, a: o.a, key: o.key, buf: o.buf})
+}
+
+func (e *anonEncoder) AddString(key, value string) {
+	if e.a.fields[key] {
+		value = e.a.anonymizeList(value)
+	}
+	e.ObjectEncoder.AddString(key, value)
+}
+
+func (e *anonEncoder) AddByteString(key string, value []byte) {
+	if e.a.fields[key] {
+		e.ObjectEncoder.AddString(key, e.a.anonymizeList(string(value)))
+		return
+	}
+	e.ObjectEncoder.AddByteString(key, value)
+}
+
+func (e *anonEncoder) AddObject(key string, m zapcore.ObjectMarshaler) error {
+	return e.ObjectEncoder.AddObject(key, e.buf.anonObject(e.a, m))
+}
+
+func (e *anonEncoder) AddArray(key string, m zapcore.ArrayMarshaler) error {
+	return e.ObjectEncoder.AddArray(key, &anonArray{a: e.a, m: m, key: key, buf: e.buf})
+}
+
+func (e *anonArrayEncoder) AppendString(value string) {
+	if e.a.fields[e.key] {
+		value = e.a.anonymizeList(value)
+	}
+	e.ArrayEncoder.AppendString(value)
+}
+
+func (e *anonArrayEncoder) AppendByteString(value []byte) {
+	if e.a.fields[e.key] {
+		e.ArrayEncoder.AppendString(e.a.anonymizeList(string(value)))
+		return
+	}
+	e.ArrayEncoder.AppendByteString(value)
+}
+
+func (e *anonArrayEncoder) AppendObject(m zapcore.ObjectMarshaler) error {
+	return e.ArrayEncoder.AppendObject(e.buf.anonObject(e.a, m))
+}
+
+func (e *anonArrayEncoder) AppendArray(m zapcore.ArrayMarshaler) error {
+	return e.ArrayEncoder.AppendArray(&anonArray{a: e.a, m: m, key: e.key, buf: e.buf})
+}
+
+func (a *ipAnonymizer) anonymizeList(v string) string {
+	if !strings.Contains(v, ",") {
+		return a.anonymize(strings.TrimSpace(v))
+	}
+	parts := strings.Split(v, ",")
+	for i, p := range parts {
+		parts[i] = a.anonymize(strings.TrimSpace(p))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// anonymize handles one address, with or without a port. Values that are not
+// addresses are returned unchanged.
+func (a *ipAnonymizer) anonymize(v string) string {
+	if v == "" {
+		return v
+	}
+	addr, err := netip.ParseAddr(v)
+	if err != nil {
+		host, _, splitErr := net.SplitHostPort(v)
+		if splitErr != nil {
+			return v
+		}
+		if addr, err = netip.ParseAddr(host); err != nil {
+			return v
+		}
+	}
+	addr = addr.Unmap().WithZone("")
+	if a.cfg.Pseudonymize {
+		return a.pseudonym(addr)
+	}
+	bits := a.cfg.IPv6Prefix
+	if addr.Is4() {
+		bits = a.cfg.IPv4Prefix
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return v
+	}
+	return prefix.Addr().String()
+}
+
+func (a *ipAnonymizer) pseudonym(addr netip.Addr) string {
+	m := hmac.New(sha256.New, a.key(time.Now()))
+	m.Write(addr.AsSlice())
+	return "ip-" + hex.EncodeToString(m.Sum(nil)[:8])
+}
+
+// key returns the pseudonym key of t's UTC day, derived from the secret
+func (a *ipAnonymizer) key(t time.Time) []byte {
+	day := t.UTC().Format(time.DateOnly)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if day != a.day {
+		m := hmac.New(sha256.New, a.cfg.Secret)
+		m.Write([]byte(day))
+		a.day, a.dayKey = day, m.Sum(nil)
+	}
+	return a.dayKey
+}
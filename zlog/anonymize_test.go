@@ -0,0 +1,168 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type panicStringer struct{}
+
+func (panicStringer) String() string { panic("boom") }
+
+func TestAnonymize(t *testing.T) {
+	a := newIPAnonymizer(IPAnonymizer{})
+	for in, want := range map[string]string{
+		"203.0.113.57":             "203.0.113.0",
+		"203.0.113.57:8080":        "203.0.113.0",
+		"2001:db8:1:2::7":          "2001:db8:1::",
+		"[2001:db8:1:2::7]:443":    "2001:db8:1::",
+		"::ffff:203.0.113.57":      "203.0.113.0",
+		"203.0.113.57, 10.1.2.3":   "203.0.113.0, 10.1.2.0",
+		"unknown, 198.51.100.7:81": "unknown, 198.51.100.0",
+		"not an address":           "not an address",
+		"":                         "",
+	} {
+		if got := a.anonymizeList(in); got != want {
+			t.Errorf("anonymizeList(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPseudonymDaily(t *testing.T) {
+	a := newIPAnonymizer(IPAnonymizer{Pseudonymize: true, Secret: []byte("s")})
+	day := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	k1 := string(a.key(day))
+	if string(a.key(day.Add(time.Hour))) != k1 {
+		t.Fatal("key changed within a day")
+	}
+	if string(a.key(day.Add(24*time.Hour))) == k1 {
+		t.Fatal("key kept the next day")
+	}
+	if p := a.anonymize("203.0.113.57"); p != a.anonymize("203.0.113.57:1") || p == a.anonymize("203.0.113.58") {
+		t.Fatalf("pseudonyms do not identify addresses: %s", p)
+	}
+}
+
+func TestIPAnonymizationFields(t *testing.T) {
+	p, rec := Test(t, WithIPAnonymization(IPAnonymizer{Fields: []string{"client", "remote_ip", "hops"}}))
+	log := p.Access.With(zap.String("client", "198.51.100.7"))
+	log.Info("request",
+		zap.ByteString("remote_ip", []byte("203.0.113.57")),
+		zap.String("x_forwarded_for", "203.0.113.57"), // not configured
+		zap.Object("peer", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("client", "203.0.113.57")
+			return enc.AddObject("via", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddByteString("client", []byte("198.51.100.9"))
+				return nil
+			}))
+		})),
+		zap.Strings("hops", []string{"192.0.2.1", "192.0.2.2"}),
+	)
+	f := rec.Access()[0].Fields
+	peer, _ := f["peer"].(map[string]any)
+	via, _ := peer["via"].(map[string]any)
+	hops, _ := f["hops"].([]any)
+	for _, c := range []struct {
+		name      string
+		got, want any
+	}{
+		{"With field", f["client"], "198.51.100.0"},
+		{"byte string", f["remote_ip"], "203.0.113.0"},
+		{"not configured", f["x_forwarded_for"], "203.0.113.57"},
+		{"nested object", peer["client"], "203.0.113.0"},
+		{"nested byte string", via["client"], "198.51.100.0"},
+		{"array", hops, []any{"192.0.2.0", "192.0.2.0"}},
+	} {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s: %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	rec.Reset()
+	p.Access.Info("request", zap.Stringer("hops", net.ParseIP("192.0.2.1")))
+	if got := rec.Access()[0].Fields["hops"]; got != "192.0.2.0" {
+		t.Errorf("stringer: %v", got)
+	}
+	p.Access.Info("request", zap.Stringer("hops", panicStringer{}))
+	if got, _ := rec.Access()[1].Fields["hopsError"].(string); got == "" {
+		t.Errorf("panicking stringer not reported by zap: %v", rec.Access()[1].Fields)
+	}
+}
+
+func TestIPAnonymizationRecordAndErrors(t *testing.T) {
+	p, rec := Test(t, WithIPAnonymization(IPAnonymizer{Fields: []string{"client"}}))
+	r := testRecord()
+	p.LogAccess(r)
+	if got := rec.Access()[0].Fields["remote_ip"]; got != r.IP {
+		t.Errorf("remote_ip rewritten without being configured: %v", got)
+	}
+
+	p, rec = Test(t, WithIPAnonymization(IPAnonymizer{}))
+	p.LogAccess(r)
+	p.Error.Error("failed", zap.String("remote_ip", "203.0.113.57"))
+	if got := rec.Access()[0].Fields["remote_ip"]; got != "203.0.113.0" {
+		t.Errorf("record remote_ip = %v", got)
+	}
+	if got := rec.Error()[0].Fields["remote_ip"]; got != "203.0.113.0" {
+		t.Errorf("error logger remote_ip = %v", got)
+	}
+	if r.IP != "203.0.113.7" {
+		t.Errorf("record modified: %s", r.IP)
+	}
+}
+
+// Sensitive fields reach the sinks as such when the anonymizer is on, and
+// Lazy fields stay lazy
+func TestIPAnonymizationSensitiveAndLazy(t *testing.T) {
+	trusted := filepath.Join(t.TempDir(), "audit.log")
+	var console bytes.Buffer
+	evaluated := 0
+	p, err := New(
+		WithIPAnonymization(IPAnonymizer{}),
+		WithSensitiveHash([]byte("secret")),
+		WithAccessFile(trusted, 1, 0, 0, false, Trusted()),
+		WithAccessConsole(&console, zapcore.DebugLevel, zapcore.FatalLevel),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Access.With(Sensitive("token", "t0k")).Info("payment",
+		Sensitive("card", "4111"),
+		Lazy("remote_ip", func() any { evaluated++; return "203.0.113.57" }),
+	)
+	p.Access.Debug("disabled", Lazy("remote_ip", func() any { evaluated++; return "" }))
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if evaluated != 1 {
+		t.Fatalf("lazy fields evaluated %d times, want once", evaluated)
+	}
+
+	var file, cons map[string]any
+	if err := json.Unmarshal([]byte(readLines(t, trusted)[0]), &file); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(console.Bytes()), &cons); err != nil {
+		t.Fatal(err)
+	}
+	if file["card"] != "4111" || file["token"] != "t0k" || file["remote_ip"] != "203.0.113.0" {
+		t.Errorf("trusted sink %v", file)
+	}
+	for _, k := range []string{"card", "token"} {
+		if s, _ := cons[k].(string); !strings.HasPrefix(s, "hmac:") {
+			t.Errorf("hashed sink %s = %v", k, cons[k])
+		}
+	}
+	if cons["remote_ip"] != "203.0.113.0" {
+		t.Errorf("hashed sink remote_ip = %v", cons["remote_ip"])
+	}
+}
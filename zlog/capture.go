@@ -0,0 +1,202 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+type (
+	// BodyCapture configures CaptureBodies
+	BodyCapture struct {
+		// MaxBytes is the most captured of each body; default 4096
+		MaxBytes int
+		// ContentTypes lists the media types captured, such as
+		// "application/json" or "text/*"; default application/json
+		ContentTypes []string
+		// Routes lists the path prefixes captured; empty means all
+		Routes []string
+		// RedactFields are JSON object keys whose values are replaced with
+		// "REDACTED" at any depth, compared case-insensitively
+		RedactFields []string
+		// OnlyOnFailure attaches the bodies to the Pair.Error entry of failed
+		// requests (status >= FailureStatus) instead of the access entry
+		OnlyOnFailure bool
+		// FailureStatus is the lowest failed status; default 500
+		FailureStatus int
+	}
+
+	bodyCapture struct {
+		BodyCapture
+		redact map[string]bool
+	}
+
+	// captureBuffer keeps the first max bytes written to it
+	captureBuffer struct {
+		buf       bytes.Buffer
+		max       int
+		truncated bool
+		json      bool
+	}
+
+	// teeBody captures what the handler reads of a request body
+	teeBody struct {
+		io.ReadCloser
+		buf *captureBuffer
+	}
+)
+
+// CaptureBodies makes Middleware log request and response bodies, within
+// the limits of c. Only what the handler reads of the request body is
+// captured. JSON bodies are redacted before they are logged; a truncated
+// JSON body cannot be redacted and is left out when RedactFields is set.
+func CaptureBodies(c BodyCapture) MiddlewareOption {
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = 4096
+	}
+	if len(c.ContentTypes) == 0 {
+		c.ContentTypes = []string{"application/json"}
+	}
+	if c.FailureStatus <= 0 {
+		c.FailureStatus = http.StatusInternalServerError
+	}
+	bc := &bodyCapture{BodyCapture: c, redact: map[string]bool{}}
+	for _, f := range c.RedactFields {
+		bc.redact[strings.ToLower(f)] = true
+	}
+	return func(m *middlewareCfg) { m.capture = bc }
+}
+
+func (c *bodyCapture) route(path string) bool {
+	if len(c.Routes) == 0 {
+		return true
+	}
+	for _, r := range c.Routes {
+		if strings.HasPrefix(path, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// contentType reports whether the media type of header is captured, and
+// whether it is JSON
+func (c *bodyCapture) contentType(header string) (ok, isJSON bool) {
+	mt, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false, false
+	}
+	isJSON = mt == "application/json" || strings.HasSuffix(mt, "+json")
+	for _, want := range c.ContentTypes {
+		if prefix, wild := strings.CutSuffix(want, "/*"); wild {
+			if strings.HasPrefix(mt, prefix+"/") {
+				return true, isJSON
+			}
+		} else if mt == want {
+			return true, isJSON
+		}
+	}
+	return false, isJSON
+}
+
+func (c *bodyCapture) failed(status int) bool {
+	return status >= c.FailureStatus
+}
+
+func (c *bodyCapture) captureRequest(r *http.Request) *captureBuffer {
+	ok, isJSON := c.contentType(r.Header.Get("Content-Type"))
+	if !ok || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	buf := &captureBuffer{max: c.MaxBytes, json: isJSON}
+	r.Body = &teeBody{ReadCloser: r.Body, buf: buf}
+	return buf
+}
+
+func (c *bodyCapture) captureResponse(w *responseRecorder) {
+	if ok, isJSON := c.contentType(w.Header().Get("Content-Type")); ok {
+		w.body = &captureBuffer{max: c.MaxBytes, json: isJSON}
+	}
+}
+
+// fields renders the captured bodies
+func (c *bodyCapture) fields(req, resp *captureBuffer) []zap.Field {
+	var fs []zap.Field
+	for _, b := range []struct {
+		key string
+		buf *captureBuffer
+	}{{"request_body", req}, {"response_body", resp}} {
+		if b.buf == nil || b.buf.buf.Len() == 0 {
+			continue
+		}
+		body, ok := c.render(b.buf)
+		if !ok {
+			fs = append(fs, zap.String(b.key, redactedValue))
+			continue
+		}
+		fs = append(fs, zap.String(b.key, body))
+		if b.buf.truncated {
+			fs = append(fs, zap.Bool(b.key+"_truncated", true))
+		}
+	}
+	return fs
+}
+
+// render redacts a JSON body; ok is false when it has to be left out
+func (c *bodyCapture) render(b *captureBuffer) (string, bool) {
+	if !b.json || len(c.redact) == 0 {
+		return b.buf.String(), true
+	}
+	if b.truncated {
+		return "", false
+	}
+	dec := json.NewDecoder(bytes.NewReader(b.buf.Bytes()))
+	dec.UseNumber()
+	var v any
+	if dec.Decode(&v) != nil {
+		return "", false
+	}
+	out, err := json.Marshal(c.redactValue(v))
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}
+
+func (c *bodyCapture) redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if c.redact[strings.ToLower(k)] {
+				v[k] = redactedValue
+			} else {
+				v[k] = c.redactValue(val)
+			}
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = c.redactValue(val)
+		}
+	}
+	return v
+}
+
+func (b *captureBuffer) Write(p []byte) {
+	room := b.max - b.buf.Len()
+	if len(p) > room {
+		p = p[:max(room, 0)]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.buf.Write(p[:n])
+	return n, err
+}
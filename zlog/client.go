@@ -0,0 +1,226 @@
+package zlog
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// ClientOption configures RoundTripper
+	ClientOption func(*clientCfg)
+
+	clientCfg struct {
+		header    string
+		keepQuery map[string]bool
+	}
+
+	// loggingTransport is the http.RoundTripper returned by RoundTripper
+	loggingTransport struct {
+		pair *Pair
+		next http.RoundTripper
+		cfg  clientCfg
+	}
+
+	// loggedBody logs the call once the response body is read to the end or
+	// closed, so the entry has the full latency and byte count
+	loggedBody struct {
+		io.ReadCloser
+		*bodyLog
+	}
+
+	// bodyLog is the state of a loggedBody, apart from it so that a body
+	// dropped without being closed can still be logged once collected
+	bodyLog struct {
+		n    atomic.Int64
+		once sync.Once
+		done func(n int64, err error)
+	}
+)
+
+// errBodyNotClosed is logged for response bodies garbage collected before
+// being read to EOF or closed
+var errBodyNotClosed = errors.New("zlog: response body was not closed")
+
+const (
+	outboundMessage = "outbound request"
+	redactedValue   = "REDACTED"
+)
+
+// RequestIDHeader sets the header used to propagate the correlation ID
+// (DefaultRequestIDHeader by default)
+func RequestIDHeader(name string) ClientOption {
+	return func(c *clientCfg) { c.header = name }
+}
+
+// KeepQuery lists query parameters logged as is; the values of all other
+// parameters are replaced with "REDACTED"
+func KeepQuery(params ...string) ClientOption {
+	return func(c *clientCfg) {
+		for _, p := range params {
+			c.keepQuery[p] = true
+		}
+	}
+}
+
+// RoundTripper wraps next (http.DefaultTransport when nil) to write an
+// access entry for every outbound call: method, path, status, response
+// bytes, latency and user agent, plus host, redacted url, bytes_out, retries (see
+// WithRetry) and request_id.
+//
+// The correlation ID of the request context (see WithRequestID) is sent in
+// the request ID header unless the request already has one. Transport
+// errors, body read errors and 5xx responses are also logged to Pair.Error.
+//
+// The entry is written when the response body is read to EOF or closed
+// (right away for protocol upgrades). A body that is never closed is logged
+// with an error once it is garbage collected.
+func RoundTripper(p *Pair, next http.RoundTripper, opts ...ClientOption) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	cfg := clientCfg{header: DefaultRequestIDHeader, keepQuery: map[string]bool{}}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return &loggingTransport{pair: p, next: next, cfg: cfg}
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	id := req.Header.Get(t.cfg.header)
+	if id == "" {
+		if id = RequestID(ctx); id != "" {
+			// RoundTrippers must not modify the caller's request
+			req = req.Clone(ctx)
+			req.Header.Set(t.cfg.header, id)
+		}
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.log(req, id, 0, 0, time.Since(start), err)
+		return resp, err
+	}
+	status := resp.StatusCode
+	if status == http.StatusSwitchingProtocols {
+		// the body is the upgraded connection (an io.ReadWriteCloser), keep it
+		t.log(req, id, status, 0, time.Since(start), nil)
+		return resp, nil
+	}
+	// done must not reach the body, or the cleanup would never run
+	l := &bodyLog{done: func(n int64, err error) {
+		t.log(req, id, status, n, time.Since(start), err)
+	}}
+	body := &loggedBody{ReadCloser: resp.Body, bodyLog: l}
+	runtime.AddCleanup(body, (*bodyLog).abandon, l)
+	// the transport may keep its response until the body is done, so the
+	// caller gets a copy, the only one holding the logged body
+	cp := *resp
+	cp.Body = body
+	return &cp, nil
+}
+
+// log writes the access entry of a call and, when it failed, an error entry
+func (t *loggingTransport) log(req *http.Request, id string, status int, n int64, d time.Duration, err error) {
+	failed := err != nil || status >= http.StatusInternalServerError
+	level := zapcore.InfoLevel
+	if failed {
+		level = zapcore.WarnLevel
+	}
+	redacted := redactURL(req.URL, t.cfg.keepQuery)
+
+	fields := []zap.Field{
+		zap.String("host", req.URL.Host),
+		zap.String("url", redacted),
+		zap.Int64("bytes_out", max(req.ContentLength, 0)),
+	}
+	if r := retryOf(req.Context()); r > 0 {
+		fields = append(fields, zap.Int("retries", r))
+	}
+	if id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+
+	t.pair.LogAccess(&AccessRecord{
+		Message:   outboundMessage,
+		Level:     level,
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		Status:    status,
+		Bytes:     n,
+		Duration:  d,
+		UserAgent: req.UserAgent(),
+		Extra:     fields,
+	})
+	if !failed {
+		return
+	}
+	msg := "outbound request failed"
+	if err == nil {
+		msg = "outbound request returned a server error"
+	}
+	t.pair.Error.Error(msg, append([]zap.Field{
+		zap.String("method", req.Method),
+		zap.Int("status", status),
+		zap.Duration("duration", d),
+	}, fields...)...)
+}
+
+// redactURL renders u without user info and with the values of query
+// parameters not in keep replaced
+func redactURL(u *url.URL, keep map[string]bool) string {
+	cp := *u
+	cp.User = nil
+	if cp.RawQuery != "" {
+		q := cp.Query()
+		for k, vs := range q {
+			if keep[k] {
+				continue
+			}
+			for i := range vs {
+				vs[i] = redactedValue
+			}
+		}
+		cp.RawQuery = q.Encode()
+	}
+	return cp.String()
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	if err == io.EOF {
+		b.finish(nil)
+	} else if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish(nil)
+	return err
+}
+
+func (l *bodyLog) finish(err error) {
+	l.once.Do(func() { l.done(l.n.Load(), err) })
+}
+
+// abandon logs a body that was neither read to EOF nor closed
+func (l *bodyLog) abandon() {
+	l.finish(errBodyNotClosed)
+}
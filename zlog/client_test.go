@@ -0,0 +1,162 @@
+package zlog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testClient(t *testing.T, h http.HandlerFunc) (*http.Client, *httptest.Server, *Recorder) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	p, rec := Test(t)
+	return &http.Client{Transport: RoundTripper(p, nil, KeepQuery("page"))}, srv, rec
+}
+
+func TestRoundTripperLogsOnEOF(t *testing.T) {
+	client, srv, rec := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-ID", r.Header.Get(DefaultRequestIDHeader))
+		io.WriteString(w, "hello")
+	})
+	req, _ := http.NewRequestWithContext(WithRequestID(context.Background(), "req-1"), "GET", srv.URL+"/a?page=2&token=s3cret", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Access()) != 0 {
+		t.Fatal("logged before the body was read")
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	// read to EOF, not closed yet
+	entries := rec.Access()
+	if len(entries) != 1 {
+		t.Fatalf("%d entries after EOF, want 1", len(entries))
+	}
+	resp.Body.Close()
+	if len(rec.Access()) != 1 {
+		t.Fatal("logged again on Close")
+	}
+
+	f := entries[0].Fields
+	if f["bytes"] != 5.0 || f["status"] != 200.0 || f["request_id"] != "req-1" || resp.Header.Get("X-Seen-ID") != "req-1" {
+		t.Errorf("fields %v, request ID sent %q", f, resp.Header.Get("X-Seen-ID"))
+	}
+	u, _ := url.Parse(f["url"].(string))
+	if q := u.Query(); q.Get("page") != "2" || q.Get("token") != redactedValue {
+		t.Errorf("url %s", u)
+	}
+	if req.Header.Get(DefaultRequestIDHeader) != "" {
+		t.Error("the caller's request was modified")
+	}
+}
+
+func TestRoundTripperLogsOnClose(t *testing.T) {
+	client, srv, rec := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 1<<20))
+	})
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	entries := rec.Access()
+	if len(entries) != 1 || entries[0].Fields["bytes"] != 10.0 {
+		t.Fatalf("entries %v, want one of 10 bytes", entries)
+	}
+}
+
+// Closing the body while another goroutine reads it is allowed
+func TestRoundTripperConcurrentReadClose(t *testing.T) {
+	client, srv, rec := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		for range 100 {
+			io.WriteString(w, strings.Repeat("x", 1<<10))
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	})
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(io.Discard, resp.Body)
+	}()
+	time.Sleep(5 * time.Millisecond)
+	resp.Body.Close()
+	wg.Wait()
+	if n := len(rec.Access()); n != 1 {
+		t.Fatalf("%d entries, want 1", n)
+	}
+}
+
+func TestRoundTripperLogsAbandonedBody(t *testing.T) {
+	client, srv, rec := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "never read")
+	})
+	func() {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.Access()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("abandoned body never logged")
+		}
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	if got := rec.Access()[0].Fields["error"]; got != errBodyNotClosed.Error() {
+		t.Fatalf("error = %v", got)
+	}
+}
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestRoundTripperErrors(t *testing.T) {
+	p, rec := Test(t)
+	client := &http.Client{Transport: RoundTripper(p, failingTransport{})}
+	if _, err := client.Get("http://example.invalid/x"); err == nil {
+		t.Fatal("no error")
+	}
+	a, e := rec.Access(), rec.Error()
+	if len(a) != 1 || a[0].Level.String() != "warn" || len(e) != 1 || e[0].Message != "outbound request failed" {
+		t.Fatalf("access %v, error %v", a, e)
+	}
+
+	client, srv, rec := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if e := rec.Error(); len(e) != 1 || e[0].Fields["status"] != 502.0 {
+		t.Fatalf("error entries %v", e)
+	}
+}
@@ -0,0 +1,43 @@
+package zlog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestConsoleRoutesSplitLevels(t *testing.T) {
+	var low, high bytes.Buffer
+	p, err := New(
+		WithAccessConsole(&low, zapcore.DebugLevel, zapcore.InfoLevel),
+		WithAccessConsole(&high, zapcore.WarnLevel, zapcore.FatalLevel),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Access.Info("info")
+	p.Access.Warn("warn")
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := low.String(); !strings.Contains(got, `"info"`) || strings.Contains(got, `"warn"`) {
+		t.Errorf("low console got %q", got)
+	}
+	if got := high.String(); !strings.Contains(got, `"warn"`) || strings.Contains(got, `"info"`) {
+		t.Errorf("high console got %q", got)
+	}
+}
+
+func TestConsoleRouteNilWriter(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"access": WithAccessConsole(nil, zapcore.DebugLevel, zapcore.FatalLevel),
+		"error":  WithErrorConsole(nil, zapcore.DebugLevel, zapcore.FatalLevel),
+	} {
+		if p, err := New(opt); err == nil {
+			p.Close()
+			t.Errorf("%s: New accepted a nil console writer", name)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package zlog
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	retryKey
+	pairKey
+	attemptKey
+)
+
+// DefaultRequestIDHeader is the header carrying the correlation ID between services
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// WithRequestID returns a copy of ctx carrying the correlation ID id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the correlation ID carried by ctx, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithRetry marks requests made with ctx as the n-th retry of a call, for
+// retry loops wrapping a client that uses RoundTripper
+func WithRetry(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, retryKey, n)
+}
+
+// WithAttempt marks work done with ctx, such as a Task, as the n-th attempt,
+// counting from 1. Unlike WithRetry it does not mark the requests made by
+// that work as retries.
+func WithAttempt(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, attemptKey, n)
+}
+
+func attemptOf(ctx context.Context) int {
+	if n, ok := ctx.Value(attemptKey).(int); ok {
+		return n
+	}
+	return 1
+}
+
+func retryOf(ctx context.Context) int {
+	n, _ := ctx.Value(retryKey).(int)
+	return n
+}
+
+// WithPair returns a copy of ctx carrying p, for FromContext
+func WithPair(ctx context.Context, p *Pair) context.Context {
+	return context.WithValue(ctx, pairKey, p)
+}
+
+// FromContext returns the Pair carried by ctx, such as the task logger of
+// Task, or a Pair that discards everything
+func FromContext(ctx context.Context) *Pair {
+	if p, ok := ctx.Value(pairKey).(*Pair); ok {
+		return p
+	}
+	return nopPair
+}
+
+var nopPair = &Pair{
+	Access:      zap.NewNop(),
+	Error:       zap.NewNop(),
+	AccessLevel: zap.NewAtomicLevel(),
+	ErrorLevel:  zap.NewAtomicLevel(),
+}
@@ -0,0 +1,422 @@
+package zlog
+
+import (
+	"errors"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// sinkOutput is where a sinkCore writes. acquire returns the writer for an
+	// entry given its context and entry fields, and a release func to call once
+	// the write is done.
+	sinkOutput interface {
+		acquire(ctx, fields []zapcore.Field) (zapcore.WriteSyncer, func(), error)
+		// needsFields reports whether acquire looks at fields, so cores only
+		// keep raw context fields around when they are used
+		needsFields() bool
+		Sync() error
+		Close() error
+	}
+
+	// entryBuf is scratch space for one entry, pooled so that rewriting the
+	// fields of an entry does not allocate: the rewritten fields, the record
+	// handed to processors, a snapshot for processor rollback and the
+	// wrappers of anonymized objects
+	entryBuf struct {
+		fields []zapcore.Field
+		rec    Record
+		saved  []zapcore.Field
+		anon   []*anonObject
+		nanon  int
+	}
+
+	// staticOutput always writes to the same writer
+	staticOutput struct {
+		fileWriter
+	}
+
+	// sinkCore is zapcore.ioCore with a pluggable destination
+	sinkCore struct {
+		zapcore.LevelEnabler
+		enc zapcore.Encoder
+		out sinkOutput
+		ctx []zapcore.Field
+		// sensitive renders Sensitive fields; nil keeps them masked
+		sensitive sensitiveRenderer
+	}
+
+	// sinkGroup runs the processors of a file sink once for the file and its
+	// mirror. Fields added with With are kept back and put before the entry's
+	// fields in Write, so that the processors see them.
+	sinkGroup struct {
+		sinks []*sinkCore
+		procs processorChain
+		ctx   *withChain
+	}
+
+	// withChain holds the fields added by With, as given. Each With links to
+	// the chain of its parent instead of copying it.
+	withChain struct {
+		parent *withChain
+		fields []zapcore.Field
+	}
+
+	// sampleAll accepts every entry. Wrapped in a zap sampler, it lets
+	// loggerCore ask for the sampling decision after filtering an entry.
+	sampleAll struct{}
+)
+
+var entryBufPool = sync.Pool{New: func() any { return new(entryBuf) }}
+
+func getEntryBuf() *entryBuf {
+	return entryBufPool.Get().(*entryBuf)
+}
+
+// own returns fields copied into the buffer; fields may already be the
+// buffer's. A nil buffer allocates.
+func (b *entryBuf) own(fields []zapcore.Field) []zapcore.Field {
+	if b == nil {
+		return append([]zapcore.Field(nil), fields...)
+	}
+	b.fields = append(b.fields[:0], fields...)
+	return b.fields
+}
+
+// prepend returns the fields of ctx followed by fields, in the buffer. fields
+// must not be the buffer's.
+func (b *entryBuf) prepend(ctx *withChain, fields []zapcore.Field) []zapcore.Field {
+	b.fields = append(ctx.appendTo(b.fields[:0]), fields...)
+	return b.fields
+}
+
+func (b *entryBuf) free() {
+	clear(b.fields)
+	clear(b.saved)
+	b.rec = Record{}
+	b.fields, b.saved = b.fields[:0], b.saved[:0]
+	for _, o := range b.anon[:b.nanon] {
+		*o = anonObject{}
+	}
+	b.nanon = 0
+	entryBufPool.Put(b)
+}
+
+// with returns the chain with fields added; c may be nil
+func (c *withChain) with(fields []zapcore.Field) *withChain {
+	return &withChain{parent: c, fields: append([]zapcore.Field(nil), fields...)}
+}
+
+// appendTo appends the fields of the chain to dst, oldest first
+func (c *withChain) appendTo(dst []zapcore.Field) []zapcore.Field {
+	if c == nil {
+		return dst
+	}
+	return append(c.parent.appendTo(dst), c.fields...)
+}
+
+// sampledIn is what sampleAll.Check returns; it is never written
+var sampledIn = &zapcore.CheckedEntry{}
+
+func (sampleAll) Enabled(zapcore.Level) bool                 { return true }
+func (s sampleAll) With([]zapcore.Field) zapcore.Core        { return s }
+func (sampleAll) Write(zapcore.Entry, []zapcore.Field) error { return nil }
+func (sampleAll) Sync() error                                { return nil }
+
+func (sampleAll) Check(zapcore.Entry, *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return sampledIn
+}
+
+func nopRelease() {}
+
+func (o staticOutput) acquire(_, _ []zapcore.Field) (zapcore.WriteSyncer, func(), error) {
+	return o.fileWriter, nopRelease, nil
+}
+
+func (staticOutput) needsFields() bool { return false }
+
+func newSinkCore(enc zapcore.Encoder, out sinkOutput, lvl zapcore.LevelEnabler, sensitive sensitiveRenderer) *sinkCore {
+	return &sinkCore{LevelEnabler: lvl, enc: enc, out: out, sensitive: sensitive}
+}
+
+func (c *sinkCore) Level() zapcore.Level {
+	return zapcore.LevelOf(c.LevelEnabler)
+}
+
+func (c *sinkCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &sinkCore{LevelEnabler: c.LevelEnabler, enc: c.enc.Clone(), out: c.out, sensitive: c.sensitive}
+	fields = renderSensitive(fields, c.sensitive)
+	for i := range fields {
+		fields[i].AddTo(clone.enc)
+	}
+	if c.out.needsFields() {
+		clone.ctx = append(append(make([]zapcore.Field, 0, len(c.ctx)+len(fields)), c.ctx...), fields...)
+	}
+	return clone
+}
+
+func (c *sinkCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *sinkCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	fields = renderSensitive(fields, c.sensitive)
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	ws, release, err := c.out.acquire(c.ctx, fields)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if _, err := ws.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if ent.Level > zapcore.ErrorLevel {
+		// Since we may be crashing the program, sync the output.
+		return ws.Sync()
+	}
+	return nil
+}
+
+func (c *sinkCore) Sync() error {
+	return c.out.Sync()
+}
+
+func (g *sinkGroup) Enabled(l zapcore.Level) bool {
+	for _, s := range g.sinks {
+		if s.Enabled(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *sinkGroup) Level() zapcore.Level {
+	lvl := zapcore.InvalidLevel
+	for _, s := range g.sinks {
+		if l := s.Level(); lvl == zapcore.InvalidLevel || l < lvl {
+			lvl = l
+		}
+	}
+	return lvl
+}
+
+func (g *sinkGroup) With(fields []zapcore.Field) zapcore.Core {
+	clone := *g
+	clone.ctx = g.ctx.with(fields)
+	return &clone
+}
+
+func (g *sinkGroup) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if g.Enabled(ent.Level) {
+		return ce.AddCore(ent, g)
+	}
+	return ce
+}
+
+func (g *sinkGroup) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf := getEntryBuf()
+	defer buf.free()
+	if g.ctx != nil {
+		fields = buf.prepend(g.ctx, fields)
+	}
+	ent, fields, keep, err := g.procs.run(buf, ent, fields)
+	if !keep {
+		return err
+	}
+	for _, s := range g.sinks {
+		if !s.Enabled(ent.Level) {
+			continue
+		}
+		if werr := s.Write(ent, fields); werr != nil {
+			err = errors.Join(err, werr)
+		}
+	}
+	return err
+}
+
+func (g *sinkGroup) Sync() error {
+	var errs []error
+	for _, s := range g.sinks {
+		errs = append(errs, s.Sync())
+	}
+	return errors.Join(errs...)
+}
+
+type (
+	// fieldTransform rewrites the fields of an entry. It must not modify the
+	// slice it is given; rewritten fields go to buf, which may be nil.
+	fieldTransform func(buf *entryBuf, fields []zapcore.Field) []zapcore.Field
+
+	// loggerCore runs per-entry steps once for a logger, then hands the
+	// entry to every sink that accepts its level
+	loggerCore struct {
+		name   string
+		sinks  []zapcore.Core
+		filter *FilterSet
+		// ctx holds the fields added by With, as given, for the filter. With
+		// processors they are also kept back from the sinks and put before
+		// the entry's fields in Write, so that the processors see them.
+		ctx *withChain
+		// sampler, a zap sampler over sampleAll, drops entries once they
+		// passed the filter, in Check or Write; nil keeps them all
+		sampler   zapcore.Core
+		transform fieldTransform
+		procs     processorChain
+		stamper   *entryStamper
+		// exit keeps the Pair registered with Exit while the core is in use
+		exit *exitSyncer
+	}
+
+	// checkedCore is a loggerCore whose entry was filtered and sampled in
+	// Check
+	checkedCore struct {
+		*loggerCore
+	}
+)
+
+func newLoggerCore(sinks []zapcore.Core) *loggerCore {
+	return &loggerCore{sinks: sinks}
+}
+
+func (c *loggerCore) Enabled(l zapcore.Level) bool {
+	for _, s := range c.sinks {
+		if s.Enabled(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *loggerCore) Level() zapcore.Level {
+	lvl := zapcore.InvalidLevel
+	for _, s := range c.sinks {
+		if l := zapcore.LevelOf(s); lvl == zapcore.InvalidLevel || l < lvl {
+			lvl = l
+		}
+	}
+	return lvl
+}
+
+func (c *loggerCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	if c.filter != nil || len(c.procs) > 0 {
+		clone.ctx = c.ctx.with(fields)
+	}
+	if len(c.procs) > 0 {
+		return &clone
+	}
+	if c.transform != nil {
+		fields = c.transform(nil, fields)
+	}
+	clone.sinks = make([]zapcore.Core, len(c.sinks))
+	for i, s := range c.sinks {
+		clone.sinks[i] = s.With(fields)
+	}
+	return &clone
+}
+
+// Check filters and samples the entry right away unless a filter rule looks
+// at fields; Write does it then
+func (c *loggerCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+	if c.filter != nil && c.filter.needsFields() {
+		return ce.AddCore(ent, c)
+	}
+	if c.filter != nil && !c.filter.keep(c.name, ent, nil, nil) {
+		return ce
+	}
+	if c.sampler != nil && c.sampler.Check(ent, nil) == nil {
+		return ce
+	}
+	return ce.AddCore(ent, checkedCore{c})
+}
+
+func (c *loggerCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if c.filter != nil && !c.filter.keep(c.name, ent, fields, c.ctx) {
+		return nil
+	}
+	if c.sampler != nil && c.sampler.Check(ent, nil) == nil {
+		return nil
+	}
+	return c.write(ent, fields)
+}
+
+// writeEntry writes ent as Check and Write would, reporting whether the
+// level, filter and sampler let it through
+func (c *loggerCore) writeEntry(ent zapcore.Entry, fields []zapcore.Field) (bool, error) {
+	if !c.Enabled(ent.Level) {
+		return false, nil
+	}
+	if c.filter != nil && !c.filter.keep(c.name, ent, fields, c.ctx) {
+		return false, nil
+	}
+	if c.sampler != nil && c.sampler.Check(ent, nil) == nil {
+		return false, nil
+	}
+	return true, c.write(ent, fields)
+}
+
+// Write skips the filter and sampler, which Check already applied
+func (c checkedCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.write(ent, fields)
+}
+
+// write runs the steps after filtering and sampling and hands the entry to
+// the sinks
+func (c *loggerCore) write(ent zapcore.Entry, fields []zapcore.Field) error {
+	var buf *entryBuf
+	if c.transform != nil || len(c.procs) > 0 || c.stamper != nil {
+		buf = getEntryBuf()
+		defer buf.free()
+	}
+	if len(c.procs) > 0 && c.ctx != nil {
+		fields = buf.prepend(c.ctx, fields)
+	}
+	if c.transform != nil {
+		fields = c.transform(buf, fields)
+	}
+	var errs []error
+	if len(c.procs) > 0 {
+		ent2, fields2, keep, err := c.procs.run(buf, ent, fields)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if !keep {
+			return errors.Join(errs...)
+		}
+		ent, fields = ent2, fields2
+	}
+	if c.stamper != nil {
+		fields = c.stamper.stamp(buf, ent, fields)
+	}
+	for _, s := range c.sinks {
+		if !s.Enabled(ent.Level) {
+			continue
+		}
+		if err := s.Write(ent, fields); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *loggerCore) Sync() error {
+	var errs []error
+	for _, s := range c.sinks {
+		if err := s.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,241 @@
+package zlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// crashReport is a Go crash report (unrecovered panic or fatal error)
+	// split into its reason and goroutine stacks
+	crashReport struct {
+		reason     string
+		goroutines crashGoroutines
+	}
+
+	crashGoroutines []crashGoroutine
+
+	crashGoroutine struct {
+		id        int
+		state     string
+		frames    crashFrames
+		createdBy string
+	}
+
+	crashFrames []crashFrame
+
+	crashFrame struct {
+		function string
+		file     string
+		line     int
+	}
+
+	// crashCapture undoes SetCrashOutput when the Pair is closed
+	crashCapture struct{}
+)
+
+const crashSuffix = ".crash"
+
+var goroutineHeader = regexp.MustCompile(`^goroutine (\d+).*\[(.*)\]:$`)
+
+// CrashPath returns the file WithCrashOutput sends crash reports to for the
+// error log at errorPath
+func CrashPath(errorPath string) string {
+	return errorPath + crashSuffix
+}
+
+// crashFile picks the error log the crash file lives next to
+func (c *buildCfg) crashFile() (string, error) {
+	paths := []string{c.error.Path}
+	for _, r := range c.errorRoutes {
+		paths = append(paths, r.file.Path)
+	}
+	for _, p := range paths {
+		if p != "" && !isPathTemplate(p) {
+			return CrashPath(p), nil
+		}
+	}
+	return "", errors.New("zlog: crash output needs an error file without placeholders")
+}
+
+// ingestCrash logs a crash report left by a previous run, if any. It fails
+// if the report was not written, so that the crash file is kept.
+func ingestCrash(c *loggerCore, path string) error {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	rep := parseCrash(raw)
+	// the entry describes the crash, not the code ingesting it
+	ent := zapcore.Entry{
+		Level:   zapcore.ErrorLevel,
+		Time:    info.ModTime(),
+		Message: "previous run crashed",
+		Stack:   string(raw),
+	}
+	written, err := c.writeEntry(ent, []zapcore.Field{
+		zap.String("crash_file", path),
+		zap.String("reason", rep.reason),
+		zap.Array("goroutines", rep.goroutines),
+	})
+	if err == nil {
+		err = c.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("zlog: logging crash report %s: %w", path, err)
+	}
+	if !written {
+		return fmt.Errorf("zlog: crash report %s not logged: the error logger drops error entries", path)
+	}
+	return nil
+}
+
+// captureCrashes truncates the crash file, whose report ingestCrash logged,
+// and makes the runtime write crash reports to it
+func captureCrashes(path string) (crashCapture, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return crashCapture{}, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return crashCapture{}, err
+	}
+	// the runtime keeps its own duplicate of the descriptor
+	defer f.Close()
+	return crashCapture{}, debug.SetCrashOutput(f, debug.CrashOptions{})
+}
+
+// Close stops sending crash reports to the file
+func (crashCapture) Close() error {
+	return debug.SetCrashOutput(nil, debug.CrashOptions{})
+}
+
+// parseCrash splits a crash report into the reason (everything before the
+// first goroutine) and the goroutine stacks
+func parseCrash(raw []byte) crashReport {
+	var (
+		rep    crashReport
+		reason []string
+		g      *crashGoroutine
+	)
+	lines := strings.Split(string(raw), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if m := goroutineHeader.FindStringSubmatch(line); m != nil {
+			id, _ := strconv.Atoi(m[1])
+			rep.goroutines = append(rep.goroutines, crashGoroutine{id: id, state: m[2]})
+			g = &rep.goroutines[len(rep.goroutines)-1]
+			continue
+		}
+		if g == nil {
+			if strings.TrimSpace(line) != "" {
+				reason = append(reason, line)
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "...") {
+			continue
+		}
+		// a function line, followed by its "\tfile:line +0x.." line
+		var file string
+		var lineNo int
+		if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\t") {
+			file, lineNo = parseFrameLocation(lines[i+1])
+			i++
+		}
+		if fn, ok := strings.CutPrefix(line, "created by "); ok {
+			if j := strings.Index(fn, " in goroutine "); j >= 0 {
+				fn = fn[:j]
+			}
+			g.createdBy = fn
+			continue
+		}
+		g.frames = append(g.frames, crashFrame{function: trimArgs(line), file: file, line: lineNo})
+	}
+	rep.reason = strings.Join(reason, "\n")
+	return rep
+}
+
+// trimArgs strips the argument list of "pkg.fn(0x1, ...)"
+func trimArgs(fn string) string {
+	if strings.HasSuffix(fn, ")") {
+		if i := strings.LastIndex(fn, "("); i > 0 {
+			return fn[:i]
+		}
+	}
+	return fn
+}
+
+// parseFrameLocation parses "\t/path/file.go:12 +0x1d"
+func parseFrameLocation(s string) (string, int) {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, " +0x"); i >= 0 {
+		s = s[:i]
+	}
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return s, 0
+	}
+	n, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return s, 0
+	}
+	return s[:i], n
+}
+
+func (gs crashGoroutines) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, g := range gs {
+		if err := enc.AppendObject(g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g crashGoroutine) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("id", g.id)
+	enc.AddString("state", g.state)
+	if g.createdBy != "" {
+		enc.AddString("created_by", g.createdBy)
+	}
+	return enc.AddArray("frames", g.frames)
+}
+
+func (fs crashFrames) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, f := range fs {
+		if err := enc.AppendObject(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f crashFrame) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("func", f.function)
+	if f.file != "" {
+		enc.AddString("file", f.file)
+		enc.AddInt("line", f.line)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package zlog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const testCrash = `panic: runtime error: index out of range [3] with length 3
+
+goroutine 1 [running]:
+main.handle(0xc000012345, 0x3)
+	/src/app/main.go:42 +0x1d
+main.main()
+	/src/app/main.go:17 +0x25
+
+goroutine 7 [chan receive, 2 minutes]:
+main.worker(...)
+	/src/app/worker.go:9
+...additional frames elided...
+created by main.main in goroutine 1
+	/src/app/main.go:12 +0x3e
+`
+
+func TestParseCrash(t *testing.T) {
+	rep := parseCrash([]byte(strings.ReplaceAll(testCrash, "\n", "\r\n")))
+	if rep.reason != "panic: runtime error: index out of range [3] with length 3" {
+		t.Errorf("reason %q", rep.reason)
+	}
+	want := crashGoroutines{
+		{id: 1, state: "running", frames: crashFrames{
+			{function: "main.handle", file: "/src/app/main.go", line: 42},
+			{function: "main.main", file: "/src/app/main.go", line: 17},
+		}},
+		{id: 7, state: "chan receive, 2 minutes", createdBy: "main.main", frames: crashFrames{
+			{function: "main.worker", file: "/src/app/worker.go", line: 9},
+		}},
+	}
+	if !reflect.DeepEqual(rep.goroutines, want) {
+		t.Errorf("goroutines\n%+v\nwant\n%+v", rep.goroutines, want)
+	}
+}
+
+func TestParseFrameLocation(t *testing.T) {
+	for in, want := range map[string]struct {
+		file string
+		line int
+	}{
+		"\t/src/a.go:12 +0x1d": {"/src/a.go", 12},
+		"\t/src/a.go:12":       {"/src/a.go", 12},
+		"\tC:/src/a.go:7 +0x1": {"C:/src/a.go", 7},
+		"\t/src/a.go:x":        {"/src/a.go:x", 0},
+		"\t<autogenerated>":    {"<autogenerated>", 0},
+	} {
+		if file, line := parseFrameLocation(in); file != want.file || line != want.line {
+			t.Errorf("%q: %s:%d", in, file, line)
+		}
+	}
+}
+
+func TestIngestCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "error.log")
+	crash := CrashPath(path)
+	if err := os.WriteFile(crash, []byte(testCrash), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	crashed := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := os.Chtimes(crash, crashed, crashed); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		p, err := New(WithErrorFile(path, 1, 0, 0, false), WithCrashOutput())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := readLines(t, path)
+	if len(lines) != 1 {
+		t.Fatalf("%d entries, want the crash logged once: %q", len(lines), lines)
+	}
+	var e struct {
+		Msg        string `json:"msg"`
+		TS         string `json:"ts"`
+		Reason     string `json:"reason"`
+		CrashFile  string `json:"crash_file"`
+		Goroutines []struct {
+			ID     int `json:"id"`
+			Frames []struct {
+				Func string `json:"func"`
+			} `json:"frames"`
+		} `json:"goroutines"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Msg != "previous run crashed" || e.CrashFile != crash || !strings.HasPrefix(e.Reason, "panic: runtime error") {
+		t.Errorf("entry %+v", e)
+	}
+	if ts, ok := parseEntryTime([]byte(e.TS)); !ok || !ts.Equal(crashed) {
+		t.Errorf("entry time %v, want the crash file's %v", ts, crashed)
+	}
+	if len(e.Goroutines) != 2 || e.Goroutines[0].Frames[0].Func != "main.handle" {
+		t.Errorf("goroutines %+v", e.Goroutines)
+	}
+	if b, err := os.ReadFile(crash); err != nil || len(b) != 0 {
+		t.Errorf("crash file not truncated: %q, %v", b, err)
+	}
+}
+
+func TestIngestCrashEntry(t *testing.T) {
+	crash := filepath.Join(t.TempDir(), "error.log.crash")
+	if err := os.WriteFile(crash, []byte(testCrash), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	crashed := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := os.Chtimes(crash, crashed, crashed); err != nil {
+		t.Fatal(err)
+	}
+	p, rec := Test(t)
+	if err := ingestCrash(p.Error.Core().(*loggerCore), crash); err != nil {
+		t.Fatal(err)
+	}
+	e := rec.Error()[0]
+	if !e.Time.Equal(crashed) || e.Caller != "" || e.Stack != strings.TrimSpace(testCrash) {
+		t.Fatalf("time %v, caller %q, stack %q: want the crash file's time and contents", e.Time, e.Caller, e.Stack)
+	}
+}
+
+// A report the error logger drops makes New fail and stays in the crash file
+func TestIngestCrashDropped(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"level":        WithInitialLevels(zapcore.InfoLevel, zapcore.FatalLevel),
+		"filter":       WithFilters(FilterRule{Expr: `msg == "previous run crashed"`}),
+		"field filter": WithFilters(FilterRule{Expr: `reason contains "runtime error"`}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "error.log")
+			if err := os.WriteFile(CrashPath(path), []byte(testCrash), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if p, err := New(WithErrorFile(path, 1, 0, 0, false), WithCrashOutput(), opt); err == nil {
+				p.Close()
+				t.Fatal("New succeeded without logging the crash")
+			}
+			if b, err := os.ReadFile(CrashPath(path)); err != nil || string(b) != testCrash {
+				t.Fatalf("crash file %q, %v", b, err)
+			}
+		})
+	}
+}
+
+func TestIngestCrashMissingOrEmpty(t *testing.T) {
+	p, rec := Test(t)
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.crash")
+	if err := os.WriteFile(empty, []byte("\n \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{filepath.Join(dir, "missing.crash"), empty} {
+		if err := ingestCrash(p.Error.Core().(*loggerCore), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(rec.Error()); n != 0 {
+		t.Fatalf("%d entries logged", n)
+	}
+}
@@ -0,0 +1,189 @@
+package zlog
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	syncMode int
+
+	// syncPolicy describes when a file sink fsyncs written entries
+	syncPolicy struct {
+		mode     syncMode
+		every    int
+		interval time.Duration
+	}
+
+	// entryWriter writes a batch of entries laid out back to back in buf
+	entryWriter interface {
+		WriteEntries(buf []byte, lens []int) error
+	}
+
+	// durableWriter applies a syncPolicy on top of a file writer.
+	// Every Write is counted as one entry (zap writes one entry per call).
+	// Sync always makes all entries written before the call durable,
+	// whatever the policy is.
+	durableWriter struct {
+		ws     zapcore.WriteSyncer
+		policy syncPolicy
+
+		written atomic.Uint64 // entries handed to ws
+
+		mu      sync.Mutex
+		cond    *sync.Cond
+		synced  uint64 // entries known to be on stable storage
+		trigger uint64 // value of written at the last EveryN trigger
+		syncing bool   // a group commit leader is running fsync
+
+		stop chan struct{}
+		done chan struct{}
+	}
+)
+
+const (
+	syncNever syncMode = iota
+	syncAlways
+	syncEveryN
+	syncInterval
+)
+
+func newDurableWriter(ws zapcore.WriteSyncer, p syncPolicy) *durableWriter {
+	d := &durableWriter{ws: ws, policy: p}
+	d.cond = sync.NewCond(&d.mu)
+	if p.mode == syncInterval {
+		d.stop = make(chan struct{})
+		d.done = make(chan struct{})
+		go d.loop()
+	}
+	return d
+}
+
+// Write does not serialize writers: the count is bumped once ws.Write has
+// returned, so an fsync started after that covers the entry. Failed writes
+// are not counted.
+func (d *durableWriter) Write(p []byte) (int, error) {
+	n, err := d.ws.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, d.apply(d.written.Add(1))
+}
+
+// WriteEntries writes a batch of entries and applies the policy once for all
+// of them
+func (d *durableWriter) WriteEntries(buf []byte, lens []int) error {
+	if ew, ok := d.ws.(entryWriter); ok {
+		if err := ew.WriteEntries(buf, lens); err != nil {
+			return err
+		}
+		return d.apply(d.written.Add(uint64(len(lens))))
+	}
+	// count the entries written before a failure, a later Sync covers them
+	for i, n := range lens {
+		if _, err := d.ws.Write(buf[:n]); err != nil {
+			d.written.Add(uint64(i))
+			return err
+		}
+		buf = buf[n:]
+	}
+	return d.apply(d.written.Add(uint64(len(lens))))
+}
+
+// apply runs the policy after entries up to seq were written
+func (d *durableWriter) apply(seq uint64) error {
+	switch d.policy.mode {
+	case syncAlways:
+		return d.waitDurable(seq)
+	case syncEveryN:
+		d.mu.Lock()
+		due := seq-d.trigger >= uint64(d.policy.every)
+		if due {
+			d.trigger = seq
+		}
+		d.mu.Unlock()
+		if due {
+			return d.waitDurable(seq)
+		}
+	}
+	return nil
+}
+
+// Sync returns once every entry written before the call is on stable storage
+func (d *durableWriter) Sync() error {
+	return d.waitDurable(d.written.Load())
+}
+
+// waitDurable blocks until entry seq is synced. The first caller to find no
+// fsync in flight becomes the leader and syncs everything written so far;
+// callers arriving meanwhile wait and are usually covered by that fsync.
+func (d *durableWriter) waitDurable(seq uint64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for d.synced < seq {
+		if d.syncing {
+			d.cond.Wait()
+			continue
+		}
+		d.syncing = true
+		target := d.written.Load()
+		d.mu.Unlock()
+		err := d.ws.Sync()
+		d.mu.Lock()
+		d.syncing = false
+		if err == nil && target > d.synced {
+			d.synced = target
+		}
+		d.cond.Broadcast()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *durableWriter) loop() {
+	defer close(d.done)
+	t := time.NewTicker(d.policy.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-t.C:
+			_ = d.Sync()
+		}
+	}
+}
+
+// Close stops the interval goroutine, syncs outstanding entries and closes the file
+func (d *durableWriter) Close() error {
+	if d.stop != nil {
+		close(d.stop)
+		<-d.done
+	}
+	err := d.Sync()
+	if c, ok := d.ws.(interface{ Close() error }); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// syncDir makes file creations and renames in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
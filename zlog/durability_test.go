@@ -0,0 +1,162 @@
+package zlog
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingSyncer records writes and fsyncs; writes fail while fail is set
+type countingSyncer struct {
+	mu     sync.Mutex
+	writes int
+	syncs  atomic.Int64
+	fail   error
+}
+
+func (s *countingSyncer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return 0, s.fail
+	}
+	s.writes++
+	return len(p), nil
+}
+
+func (s *countingSyncer) Sync() error {
+	s.syncs.Add(1)
+	return nil
+}
+
+func TestDurableWriterAlways(t *testing.T) {
+	ws := &countingSyncer{}
+	d := newDurableWriter(ws, syncPolicy{mode: syncAlways})
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if _, err := d.Write([]byte("x\n")); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := d.written.Load(); got != 800 {
+		t.Fatalf("written = %d, want 800", got)
+	}
+	if d.synced != 800 {
+		t.Fatalf("synced = %d, want 800", d.synced)
+	}
+	if n := ws.syncs.Load(); n == 0 || n > 800 {
+		t.Fatalf("%d fsyncs for 800 entries", n)
+	}
+}
+
+func TestDurableWriterEveryN(t *testing.T) {
+	ws := &countingSyncer{}
+	d := newDurableWriter(ws, syncPolicy{mode: syncEveryN, every: 3})
+	for range 9 {
+		if _, err := d.Write([]byte("x\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := ws.syncs.Load(); n != 3 {
+		t.Fatalf("fsyncs = %d, want 3", n)
+	}
+	if err := d.WriteEntries([]byte("a\nb\nc\n"), []int{2, 2, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if n := ws.syncs.Load(); n != 4 {
+		t.Fatalf("fsyncs = %d, want 4", n)
+	}
+}
+
+func TestDurableWriterNever(t *testing.T) {
+	ws := &countingSyncer{}
+	d := newDurableWriter(ws, syncPolicy{mode: syncNever})
+	for range 10 {
+		if _, err := d.Write([]byte("x\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := ws.syncs.Load(); n != 0 {
+		t.Fatalf("fsyncs = %d, want 0", n)
+	}
+	if err := d.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := ws.syncs.Load(); n != 1 {
+		t.Fatalf("fsyncs after Sync = %d, want 1", n)
+	}
+	// nothing new to make durable
+	if err := d.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := ws.syncs.Load(); n != 1 {
+		t.Fatalf("fsyncs after second Sync = %d, want 1", n)
+	}
+}
+
+func TestDurableWriterInterval(t *testing.T) {
+	ws := &countingSyncer{}
+	d := newDurableWriter(ws, syncPolicy{mode: syncInterval, interval: 5 * time.Millisecond})
+	defer d.Close()
+	if _, err := d.Write([]byte("x\n")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for ws.syncs.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("interval fsync did not run")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDurableWriterFailedWriteNotCounted(t *testing.T) {
+	errDisk := errors.New("disk full")
+	ws := &countingSyncer{fail: errDisk}
+	d := newDurableWriter(ws, syncPolicy{mode: syncAlways})
+	if _, err := d.Write([]byte("x\n")); !errors.Is(err, errDisk) {
+		t.Fatalf("err = %v, want %v", err, errDisk)
+	}
+	if got := d.written.Load(); got != 0 {
+		t.Fatalf("written = %d after a failed write", got)
+	}
+	if n := ws.syncs.Load(); n != 0 {
+		t.Fatalf("fsyncs = %d after a failed write", n)
+	}
+}
+
+// failAfter fails every write after the first n
+type failAfter struct {
+	countingSyncer
+	n int
+}
+
+func (f *failAfter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writes >= f.n {
+		return 0, errors.New("short write")
+	}
+	f.writes++
+	return len(p), nil
+}
+
+func TestDurableWriterPartialBatch(t *testing.T) {
+	ws := &failAfter{n: 2}
+	d := newDurableWriter(ws, syncPolicy{mode: syncNever})
+	if err := d.WriteEntries([]byte("a\nb\nc\n"), []int{2, 2, 2}); err == nil {
+		t.Fatal("want an error")
+	}
+	if got := d.written.Load(); got != 2 {
+		t.Fatalf("written = %d, want the 2 entries before the failure", got)
+	}
+}
@@ -0,0 +1,327 @@
+package zlog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type (
+	// EraseOptions selects the entries removed by Erase
+	EraseOptions struct {
+		// Path is the active log file; its rotated backups (plain or gzipped)
+		// are found next to it
+		Path string
+		// Field and Value select the entries to remove: JSON lines whose
+		// top-level Field equals Value (strings are compared unquoted, other
+		// JSON values by their literal text). Files holding lines that are not
+		// JSON objects, such as console-encoded logs, make Erase fail.
+		Field string
+		Value string
+		// TimeKey is the field holding entry times, read to refresh the first
+		// and last times of rewritten backups in the manifest; default "ts"
+		TimeKey string
+		// DryRun counts matching entries without rewriting anything
+		DryRun bool
+		// LocalTime must match the LocalTimeBackups setting of the sink
+		LocalTime bool
+	}
+
+	// ErasureReport records what Erase did. It holds a hash of the erased
+	// value rather than the value itself.
+	ErasureReport struct {
+		Path      string       `json:"path"`
+		Field     string       `json:"field"`
+		ValueHash string       `json:"value_sha256"`
+		DryRun    bool         `json:"dry_run"`
+		Started   time.Time    `json:"started"`
+		Finished  time.Time    `json:"finished"`
+		Files     []ErasedFile `json:"files"`
+		Removed   int64        `json:"removed"`
+		// ManifestUpdated is set when checksums in the manifest were rewritten
+		ManifestUpdated bool `json:"manifest_updated"`
+	}
+
+	// ErasedFile is one log file scanned by Erase
+	ErasedFile struct {
+		Name       string `json:"name"`
+		Compressed bool   `json:"compressed"`
+		Scanned    int64  `json:"scanned"`
+		Removed    int64  `json:"removed"`
+	}
+
+	// rewriteResult describes a log file after rewriteLog
+	rewriteResult struct {
+		kept        int64
+		removed     int64
+		size        int64     // uncompressed size of the kept entries
+		sum         string    // SHA-256 of the kept entries, uncompressed
+		first, last time.Time // times of the first and last kept entries, if read
+	}
+)
+
+// errNotJSON reports a log line Erase cannot match
+var errNotJSON = errors.New("not a JSON entry; only JSON-encoded logs can be erased")
+
+// Erase removes the entries matching opts from the active log file and all
+// its backups, keeping file names and compression, and refreshes the
+// checksum manifest if there is one.
+//
+// Files are replaced atomically, so a process still writing the active file
+// keeps writing to the replaced copy: stop the writer (or rotate and erase
+// the backups only) before erasing the active file. The cleanup of a running
+// sink (compression, retention) waits for Erase to finish, and the other way
+// around, through a lock on Path+".lock" on unix systems.
+//
+// If a file cannot be rewritten, Erase stops there and returns the error
+// with the report of the files rewritten before it, whose manifest entries
+// are refreshed.
+func Erase(opts EraseOptions) (*ErasureReport, error) {
+	sum := sha256.Sum256([]byte(opts.Value))
+	rep := &ErasureReport{
+		Path:      opts.Path,
+		Field:     opts.Field,
+		ValueHash: hex.EncodeToString(sum[:]),
+		DryRun:    opts.DryRun,
+		Started:   time.Now().UTC(),
+	}
+	if opts.Path == "" || opts.Field == "" {
+		return nil, errors.New("zlog: erase needs a path and a field")
+	}
+
+	if opts.TimeKey == "" {
+		opts.TimeKey = "ts"
+	}
+
+	match := fieldMatcher(opts.Field, opts.Value)
+	keep := func(line []byte) (bool, error) {
+		m, err := match(line)
+		return !m, err
+	}
+	timeOf := entryTimeFunc(EncodingJSON, opts.TimeKey)
+
+	unlock, err := lockFile(opts.Path + lockSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	backups, err := listBackups(opts.Path, opts.LocalTime)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(opts.Path)
+	results := map[string]rewriteResult{}
+
+	// a file that cannot be rewritten stops Erase, but the manifest is
+	// still brought up to date with the files rewritten before it
+	for _, b := range backups {
+		var res rewriteResult
+		res, err = rewriteLog(filepath.Join(dir, b.name), b.gz, keep, timeOf, opts.DryRun)
+		if err != nil {
+			break
+		}
+		rep.add(b.name, b.gz, res)
+		if res.removed > 0 {
+			results[b.name] = res
+		}
+	}
+	if _, statErr := os.Stat(opts.Path); err == nil && statErr == nil {
+		var res rewriteResult
+		if res, err = rewriteLog(opts.Path, false, keep, timeOf, opts.DryRun); err == nil {
+			rep.add(filepath.Base(opts.Path), false, res)
+		}
+	}
+
+	if !opts.DryRun && len(results) > 0 {
+		updated, merr := updateManifest(ManifestPath(opts.Path), results)
+		rep.ManifestUpdated = updated && merr == nil
+		err = errors.Join(err, merr)
+	}
+	rep.Finished = time.Now().UTC()
+	return rep, err
+}
+
+func (r *ErasureReport) add(name string, gz bool, res rewriteResult) {
+	r.Files = append(r.Files, ErasedFile{
+		Name:       name,
+		Compressed: gz,
+		Scanned:    res.kept + res.removed,
+		Removed:    res.removed,
+	})
+	r.Removed += res.removed
+}
+
+// fieldMatcher matches JSON lines whose top-level field equals value. Blank
+// lines never match; other lines that are not JSON objects are an error.
+func fieldMatcher(field, value string) func([]byte) (bool, error) {
+	return func(line []byte) (bool, error) {
+		if len(line) == 0 {
+			return false, nil
+		}
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(line, &obj) != nil {
+			return false, errNotJSON
+		}
+		raw, ok := obj[field]
+		if !ok {
+			return false, nil
+		}
+		if len(raw) > 0 && raw[0] == '"' {
+			var s string
+			return json.Unmarshal(raw, &s) == nil && s == value, nil
+		}
+		return string(bytes.TrimSpace(raw)) == value, nil
+	}
+}
+
+// updateManifest refreshes size, entry count and checksum of rewritten
+// backups. It reports whether a manifest exists.
+func updateManifest(manifest string, results map[string]rewriteResult) (bool, error) {
+	if _, err := os.Stat(manifest); os.IsNotExist(err) {
+		return false, nil
+	}
+	entries, err := ReadManifest(manifest)
+	if err != nil {
+		return false, err
+	}
+	applyRewrites(entries, results)
+	return true, writeManifest(manifest, entries)
+}
+
+// applyRewrites updates manifest entries of backups rewritten by rewriteLog.
+// First and Last are kept when the times of the remaining entries could not
+// be read, and cleared when no entry remains.
+func applyRewrites(entries []ManifestEntry, results map[string]rewriteResult) {
+	for i, e := range entries {
+		res, ok := results[e.Name]
+		if !ok {
+			continue
+		}
+		entries[i].Size = res.size
+		entries[i].Entries = res.kept
+		entries[i].SHA256 = res.sum
+		switch {
+		case res.kept == 0:
+			entries[i].First, entries[i].Last = time.Time{}, time.Time{}
+		case !res.first.IsZero():
+			entries[i].First, entries[i].Last = res.first.UTC(), res.last.UTC()
+		}
+	}
+}
+
+// rewriteLog copies the entries of a log file for which keep returns true
+// into a temporary file and, unless nothing was dropped or dryRun is set,
+// atomically replaces the original with it. gz selects name.gz and keeps the
+// result gzipped. timeOf, if set, reads the times of the kept entries. An
+// error from keep stops the rewrite and leaves the file unchanged.
+func rewriteLog(name string, gz bool, keep func(line []byte) (bool, error), timeOf func([]byte) (time.Time, bool), dryRun bool) (res rewriteResult, err error) {
+	src := name
+	if gz {
+		src += compressSuffix
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return res, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return res, err
+	}
+
+	var r io.Reader = in
+	if gz {
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return res, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(src), ".zlog-rewrite-*")
+	if err != nil {
+		return res, err
+	}
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	var w io.Writer = tmp
+	var zw *gzip.Writer
+	if gz {
+		zw = gzip.NewWriter(tmp)
+		w = zw
+	}
+	h := sha256.New()
+	out := io.MultiWriter(w, h)
+
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, readErr := br.ReadBytes('\n')
+		if len(line) > 0 {
+			entry := bytes.TrimSpace(line)
+			ok, err := keep(entry)
+			if err != nil {
+				return res, fmt.Errorf("zlog: %s:%d: %w", src, n, err)
+			}
+			if ok {
+				if _, err := out.Write(line); err != nil {
+					return res, err
+				}
+				res.kept++
+				res.size += int64(len(line))
+				if timeOf != nil {
+					if t, ok := timeOf(entry); ok {
+						if res.first.IsZero() {
+							res.first = t
+						}
+						res.last = t
+					}
+				}
+			} else {
+				res.removed++
+			}
+		}
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return res, readErr
+		}
+	}
+	res.sum = hex.EncodeToString(h.Sum(nil))
+	if dryRun || res.removed == 0 {
+		return res, nil
+	}
+
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return res, err
+		}
+	}
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		return res, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return res, err
+	}
+	if err := tmp.Close(); err != nil {
+		return res, err
+	}
+	if err := os.Rename(tmp.Name(), src); err != nil {
+		return res, err
+	}
+	tmp = nil
+	return res, syncDir(filepath.Dir(src))
+}
@@ -0,0 +1,145 @@
+package zlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eraseFixture writes a gzipped backup with a manifest and an active file,
+// each holding entries of users 1 to 3, the first and last ones of user 42
+func eraseFixture(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "access.log")
+	w := newFileRotator(rotateCfg{Path: path, Compress: true, Manifest: true, TimeOf: entryTimeFunc(EncodingJSON, "ts")})
+	entry := func(hour, user int) string {
+		return fmt.Sprintf(`{"ts":"2024-05-06T%02d:00:00.000Z","user_id":%d}`+"\n", hour, user)
+	}
+	for i, user := range []int{42, 1, 2, 3, 42} {
+		if _, err := w.Write([]byte(entry(i, user))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.millRunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(entry(10, 1)+entry(11, 42)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestErase(t *testing.T) {
+	path := eraseFixture(t)
+	rep, err := Erase(EraseOptions{Path: path, Field: "user_id", Value: "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Removed != 3 || len(rep.Files) != 2 || !rep.ManifestUpdated {
+		t.Fatalf("report %+v", rep)
+	}
+	if !rep.Files[0].Compressed || rep.Files[0].Removed != 2 || rep.Files[0].Scanned != 5 {
+		t.Errorf("backup %+v", rep.Files[0])
+	}
+	if sum := sha256.Sum256([]byte("42")); rep.ValueHash != hex.EncodeToString(sum[:]) {
+		t.Errorf("value hash %q", rep.ValueHash)
+	}
+	for _, l := range logLines(t, path) {
+		if strings.Contains(l, `"user_id":42`) {
+			t.Errorf("entry kept: %s", l)
+		}
+	}
+
+	bad, err := VerifyManifest(ManifestPath(path))
+	if err != nil || len(bad) > 0 {
+		t.Fatalf("VerifyManifest = %v, %v", bad, err)
+	}
+	entries, err := ReadManifest(ManifestPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := entries[0]
+	if e.Entries != 3 || e.First.Hour() != 1 || e.Last.Hour() != 3 {
+		t.Fatalf("manifest entry %+v, want 3 entries from 01:00 to 03:00", e)
+	}
+}
+
+func TestEraseDryRun(t *testing.T) {
+	path := eraseFixture(t)
+	before := logLines(t, path)
+	rep, err := Erase(EraseOptions{Path: path, Field: "user_id", Value: "42", DryRun: true})
+	if err != nil || rep.Removed != 3 || rep.ManifestUpdated {
+		t.Fatalf("Erase = %+v, %v", rep, err)
+	}
+	if after := logLines(t, path); strings.Join(after, "\n") != strings.Join(before, "\n") {
+		t.Fatal("dry run changed the files")
+	}
+}
+
+func TestEraseRejectsConsoleLogs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	content := "2024-05-06T07:08:09.000Z\tinfo\trequest\t{\"user_id\": 42}\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Erase(EraseOptions{Path: path, Field: "user_id", Value: "42"})
+	if !errors.Is(err, errNotJSON) || !strings.Contains(err.Error(), "access.log:1") {
+		t.Fatalf("Erase = %v, want an error naming the line", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != content {
+		t.Fatal("file changed")
+	}
+}
+
+// A file failing after a backup was rewritten leaves the manifest matching
+// the rewritten backup
+func TestErasePartialFailure(t *testing.T) {
+	path := eraseFixture(t)
+	if err := os.WriteFile(path, []byte("not json\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := Erase(EraseOptions{Path: path, Field: "user_id", Value: "42"})
+	if !errors.Is(err, errNotJSON) {
+		t.Fatalf("Erase = %v, want the active file's error", err)
+	}
+	if rep == nil || len(rep.Files) != 1 || rep.Removed != 2 || !rep.ManifestUpdated || rep.Finished.IsZero() {
+		t.Fatalf("report %+v", rep)
+	}
+	bad, err := VerifyManifest(ManifestPath(path))
+	if err != nil || len(bad) > 0 {
+		t.Fatalf("VerifyManifest = %v, %v", bad, err)
+	}
+}
+
+func TestApplyRewrites(t *testing.T) {
+	old := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	entries := []ManifestEntry{
+		{Name: "a", First: old, Last: old},
+		{Name: "b", First: old, Last: old},
+		{Name: "c", First: old, Last: old},
+		{Name: "d", First: old, Last: old},
+	}
+	first, last := old.Add(time.Hour), old.Add(2*time.Hour)
+	applyRewrites(entries, map[string]rewriteResult{
+		"a": {kept: 2, first: first, last: last},
+		"b": {kept: 2}, // times not read
+		"c": {removed: 2},
+	})
+	for i, want := range [][2]time.Time{{first, last}, {old, old}, {}, {old, old}} {
+		if !entries[i].First.Equal(want[0]) || !entries[i].Last.Equal(want[1]) {
+			t.Errorf("%s: first=%v last=%v, want %v", entries[i].Name, entries[i].First, entries[i].Last, want)
+		}
+	}
+}
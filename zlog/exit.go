@@ -0,0 +1,238 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+	"weak"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// exitRegistry holds the process-wide state of Exit. Pairs are held
+	// through weak pointers, so a Pair that is dropped without Close stops
+	// being flushed once its loggers are unreachable.
+	exitRegistry struct {
+		mu      sync.Mutex
+		hooks   []*exitHook
+		syncers map[uint64]weak.Pointer[exitSyncer]
+		nextID  uint64
+		timeout time.Duration
+		exit    func(int)
+		// exiting is closed once the exit in progress is done; nil if none
+		exiting chan struct{}
+		// inExit holds the goroutines of the exit in progress: Exit's own,
+		// the hooks' and the flushes'
+		inExit map[uint64]bool
+	}
+
+	// exitSyncer flushes the loggers of a Pair on Exit. The Pair and every
+	// loggerCore derived from it hold it, which keeps it registered while any
+	// of its loggers is in use.
+	exitSyncer struct {
+		id    uint64
+		cores []zapcore.Core
+	}
+
+	exitHook struct {
+		fn func(context.Context)
+	}
+
+	// fatalHook makes Fatal entries go through Exit
+	fatalHook struct{}
+)
+
+// DefaultExitTimeout bounds the time Exit spends flushing and running hooks
+const DefaultExitTimeout = 5 * time.Second
+
+var exits = exitRegistry{
+	syncers: map[uint64]weak.Pointer[exitSyncer]{},
+	inExit:  map[uint64]bool{},
+	timeout: DefaultExitTimeout,
+	exit:    os.Exit,
+}
+
+// OnExit registers fn to run when the process exits through Exit or a Fatal
+// entry. Hooks run in reverse order of registration and should return once
+// ctx is done. The returned func unregisters the hook.
+func OnExit(fn func(ctx context.Context)) (remove func()) {
+	h := &exitHook{fn: fn}
+	exits.mu.Lock()
+	exits.hooks = append(exits.hooks, h)
+	exits.mu.Unlock()
+	return func() {
+		exits.mu.Lock()
+		defer exits.mu.Unlock()
+		for i, o := range exits.hooks {
+			if o == h {
+				exits.hooks = append(exits.hooks[:i], exits.hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// SetExitTimeout sets how long Exit may spend flushing sinks and running
+// hooks before it exits anyway (DefaultExitTimeout by default)
+func SetExitTimeout(d time.Duration) {
+	exits.mu.Lock()
+	exits.timeout = d
+	exits.mu.Unlock()
+}
+
+// SetExitFunc replaces os.Exit as the last step of Exit, e.g. in tests. It
+// returns the previous func.
+func SetExitFunc(fn func(code int)) (prev func(int)) {
+	exits.mu.Lock()
+	defer exits.mu.Unlock()
+	prev, exits.exit = exits.exit, fn
+	return prev
+}
+
+// Exit flushes every Pair in use, runs the OnExit hooks in reverse order,
+// flushes again to keep what the hooks logged, then exits with code. All of
+// it is bounded by the exit timeout. A call made while another goroutine
+// exits waits for that exit to finish; a nested call (a hook logging at Fatal
+// level, say) exits right away.
+func Exit(code int) {
+	id := goid()
+	exits.mu.Lock()
+	exitFn, timeout := exits.exit, exits.timeout
+	if done := exits.exiting; done != nil {
+		nested := exits.inExit[id]
+		exits.mu.Unlock()
+		if !nested {
+			<-done
+		}
+		exitFn(code)
+		return
+	}
+	done := make(chan struct{})
+	exits.exiting = done
+	exits.inExit[id] = true
+	exits.mu.Unlock()
+	defer func() {
+		exits.mu.Lock()
+		exits.exiting = nil
+		delete(exits.inExit, id)
+		exits.mu.Unlock()
+		close(done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	exits.flush(ctx)
+	exits.mu.Lock()
+	hooks := append([]*exitHook(nil), exits.hooks...)
+	exits.mu.Unlock()
+	for i := len(hooks) - 1; i >= 0 && ctx.Err() == nil; i-- {
+		runHook(ctx, hooks[i].fn)
+	}
+	exits.flush(ctx)
+	exitFn(code)
+}
+
+// runHook runs fn until it returns, panics or ctx is done
+func runHook(ctx context.Context, fn func(context.Context)) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer exits.track()()
+		defer func() { _ = recover() }()
+		fn(ctx)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// flush syncs all registered pairs in parallel until ctx is done
+func (r *exitRegistry) flush(ctx context.Context) {
+	r.mu.Lock()
+	syncers := make([]*exitSyncer, 0, len(r.syncers))
+	for _, w := range r.syncers {
+		if s := w.Value(); s != nil {
+			syncers = append(syncers, s)
+		}
+	}
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range syncers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer r.track()()
+			s.sync()
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// track counts the calling goroutine as part of the exit in progress until
+// the returned func is called
+func (r *exitRegistry) track() (untrack func()) {
+	id := goid()
+	r.mu.Lock()
+	r.inExit[id] = true
+	r.mu.Unlock()
+	return func() {
+		r.mu.Lock()
+		delete(r.inExit, id)
+		r.mu.Unlock()
+	}
+}
+
+// goid returns the id of the calling goroutine, read from its stack trace
+func goid() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// add registers a syncer for cores until remove is called or the syncer
+// becomes unreachable
+func (r *exitRegistry) add(cores ...zapcore.Core) *exitSyncer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	s := &exitSyncer{id: r.nextID, cores: cores}
+	r.syncers[s.id] = weak.Make(s)
+	runtime.AddCleanup(s, r.remove, s.id)
+	return s
+}
+
+func (r *exitRegistry) remove(id uint64) {
+	r.mu.Lock()
+	delete(r.syncers, id)
+	r.mu.Unlock()
+}
+
+func (s *exitSyncer) sync() {
+	for _, c := range s.cores {
+		_ = c.Sync()
+	}
+}
+
+// OnWrite implements zapcore.CheckWriteHook
+func (fatalHook) OnWrite(*zapcore.CheckedEntry, []zapcore.Field) {
+	Exit(1)
+}
@@ -0,0 +1,196 @@
+package zlog
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// syncWriter counts the Sync calls it gets
+type syncWriter struct {
+	syncs atomic.Int32
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func (w *syncWriter) Sync() error {
+	w.syncs.Add(1)
+	return nil
+}
+
+// captureExit replaces os.Exit for the test and returns the codes Exit
+// was called with
+func captureExit(t *testing.T) *[]int {
+	var codes []int
+	prev := SetExitFunc(func(code int) { codes = append(codes, code) })
+	t.Cleanup(func() { SetExitFunc(prev) })
+	return &codes
+}
+
+func newSyncedPair(t *testing.T, w *syncWriter) *Pair {
+	t.Helper()
+	p, err := New(WithAccessConsole(w, zapcore.DebugLevel, zapcore.FatalLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func registered(id uint64) bool {
+	exits.mu.Lock()
+	defer exits.mu.Unlock()
+	_, ok := exits.syncers[id]
+	return ok
+}
+
+func TestExit(t *testing.T) {
+	codes := captureExit(t)
+	w := &syncWriter{}
+	p := newSyncedPair(t, w)
+	defer p.Close()
+
+	var order []int
+	for i := range 3 {
+		remove := OnExit(func(context.Context) {
+			order = append(order, i)
+			p.Access.Info("hook")
+		})
+		defer remove()
+	}
+	Exit(3)
+	if !slices.Equal(*codes, []int{3}) {
+		t.Fatalf("exit codes %v", *codes)
+	}
+	if !slices.Equal(order, []int{2, 1, 0}) {
+		t.Fatalf("hooks ran in order %v, want reverse registration", order)
+	}
+	// before the hooks and after them
+	if n := w.syncs.Load(); n < 2 {
+		t.Fatalf("%d syncs", n)
+	}
+}
+
+func TestExitFatalAndNested(t *testing.T) {
+	codes := captureExit(t)
+	w := &syncWriter{}
+	p := newSyncedPair(t, w)
+	defer p.Close()
+	defer OnExit(func(context.Context) { p.Error.Fatal("from a hook") })()
+
+	p.Access.Fatal("fatal")
+	if !slices.Equal(*codes, []int{1, 1}) {
+		t.Fatalf("exit codes %v, want the nested exit then the outer one", *codes)
+	}
+}
+
+// An Exit from another goroutine waits until the exit in progress is done
+func TestExitConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	var codes []int
+	prev := SetExitFunc(func(code int) {
+		mu.Lock()
+		codes = append(codes, code)
+		mu.Unlock()
+	})
+	defer SetExitFunc(prev)
+	inHook, release := make(chan struct{}), make(chan struct{})
+	defer OnExit(func(context.Context) {
+		close(inHook)
+		<-release
+	})()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		Exit(2)
+	}()
+	<-inHook
+	go func() {
+		defer wg.Done()
+		Exit(5)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	early := slices.Clone(codes)
+	mu.Unlock()
+	close(release)
+	wg.Wait()
+	if len(early) != 0 {
+		t.Fatalf("exited with %v while hooks ran", early)
+	}
+	if !slices.Equal(codes, []int{2, 5}) {
+		t.Fatalf("exit codes %v, want the first exit then the waiting one", codes)
+	}
+}
+
+func TestExitTimeout(t *testing.T) {
+	captureExit(t)
+	SetExitTimeout(20 * time.Millisecond)
+	defer SetExitTimeout(DefaultExitTimeout)
+	ran := false
+	defer OnExit(func(context.Context) { ran = true })()
+	defer OnExit(func(context.Context) { select {} })()
+	defer OnExit(func(context.Context) { panic("skipped") })()
+
+	start := time.Now()
+	Exit(0)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Exit took %v", d)
+	}
+	if ran {
+		t.Fatal("hook ran after the timeout")
+	}
+}
+
+// Pairs dropped without Close are forgotten, unless a logger derived from
+// them is still in use
+func TestExitForgetsUnreachablePairs(t *testing.T) {
+	codes := captureExit(t)
+	w := &syncWriter{}
+	p := newSyncedPair(t, w)
+	kept := p.exit.id
+	l := p.Access.With(zap.String("k", "v"))
+	p = nil
+	var dropped []uint64
+	for range 3 {
+		dropped = append(dropped, newSyncedPair(t, &syncWriter{}).exit.id)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for slices.ContainsFunc(dropped, registered) {
+		if time.Now().After(deadline) {
+			t.Fatal("dropped pairs still registered")
+		}
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	if !registered(kept) {
+		t.Fatal("the pair of a logger in use was forgotten")
+	}
+	Exit(0)
+	if len(*codes) != 1 || w.syncs.Load() == 0 {
+		t.Fatal("the pair of a logger in use was not flushed")
+	}
+	runtime.KeepAlive(l)
+}
+
+func TestExitCloseUnregisters(t *testing.T) {
+	p := newSyncedPair(t, &syncWriter{})
+	id := p.exit.id
+	p.With(zap.String("k", "v")).Close()
+	if !registered(id) {
+		t.Fatal("closing a With pair unregistered its parent")
+	}
+	p.Close()
+	if registered(id) {
+		t.Fatal("Close left the pair registered")
+	}
+}
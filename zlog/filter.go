@@ -0,0 +1,155 @@
+package zlog
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// FilterAction is what a matching FilterRule does with an entry
+	FilterAction string
+
+	// FilterRule drops or keeps the entries matching Expr. Expr is written in
+	// a small expression language, for example
+	//
+	//	logger=="access" && path startsWith "/healthz"
+	//	level<warn && tenant=="noisy"
+	//
+	// logger is "access" or "error", level compares with level names, msg is
+	// the message and any other name is a field of the entry. Strings, numbers
+	// (durations in seconds) and booleans compare with == != < <= > >=;
+	// strings also with startsWith, endsWith, contains and matches (a regular
+	// expression). Conditions combine with &&, ||, ! and parentheses. A name
+	// alone is true when the field is set and not zero. A comparison with a
+	// missing field is false, except !=. Lazy fields are not evaluated for
+	// the filter and count as missing.
+	FilterRule struct {
+		Name   string       `json:"name,omitempty"`
+		Expr   string       `json:"expr"`
+		Action FilterAction `json:"action,omitempty"` // FilterDrop if empty
+	}
+
+	// FilterStatus is a rule with the number of entries it matched
+	FilterStatus struct {
+		FilterRule
+		Matched uint64 `json:"matched"`
+	}
+
+	// FilterSet holds the filter rules of a Pair. Rules are tried in order
+	// and the first matching rule decides; entries no rule matches are kept.
+	// Rules can be replaced at runtime with Set.
+	FilterSet struct {
+		rules atomic.Pointer[filterRules]
+	}
+
+	// filterRules is one version of the rules of a FilterSet
+	filterRules struct {
+		list []*compiledRule
+		// fields is set when a rule looks at fields, so that entries can only
+		// be filtered once they are written
+		fields bool
+	}
+
+	compiledRule struct {
+		FilterRule
+		pred    filterPred
+		fields  bool
+		matched atomic.Uint64
+	}
+)
+
+// filterEnvPool keeps the filterEnv passed to the rules from escaping per
+// entry
+var filterEnvPool = sync.Pool{New: func() any { return new(filterEnv) }}
+
+// Filter actions
+const (
+	FilterDrop FilterAction = "drop"
+	FilterKeep FilterAction = "keep"
+)
+
+// Set compiles rules and replaces the current ones. On error the current
+// rules are left in place. Rules that did not change keep their counters.
+func (s *FilterSet) Set(rules []FilterRule) error {
+	old := make(map[FilterRule]*compiledRule)
+	if cur := s.rules.Load(); cur != nil {
+		for _, r := range cur.list {
+			old[r.FilterRule] = r
+		}
+	}
+	compiled := &filterRules{list: make([]*compiledRule, 0, len(rules))}
+	for i, r := range rules {
+		if r.Action == "" {
+			r.Action = FilterDrop
+		}
+		if prev, ok := old[r]; ok {
+			compiled.list = append(compiled.list, prev)
+			compiled.fields = compiled.fields || prev.fields
+			continue
+		}
+		if r.Action != FilterDrop && r.Action != FilterKeep {
+			return fmt.Errorf("zlog: filter %s: unknown action %q", ruleName(i, r), r.Action)
+		}
+		pred, fields, err := compileFilter(r.Expr)
+		if err != nil {
+			return fmt.Errorf("zlog: filter %s: %w", ruleName(i, r), err)
+		}
+		compiled.list = append(compiled.list, &compiledRule{FilterRule: r, pred: pred, fields: fields})
+		compiled.fields = compiled.fields || fields
+	}
+	s.rules.Store(compiled)
+	return nil
+}
+
+// Rules returns the current rules with their counters
+func (s *FilterSet) Rules() []FilterStatus {
+	if s == nil {
+		return nil
+	}
+	cur := s.rules.Load()
+	if cur == nil {
+		return nil
+	}
+	out := make([]FilterStatus, len(cur.list))
+	for i, r := range cur.list {
+		out[i] = FilterStatus{FilterRule: r.FilterRule, Matched: r.matched.Load()}
+	}
+	return out
+}
+
+// keep reports whether an entry of the named logger passes the rules
+func (s *FilterSet) keep(logger string, ent zapcore.Entry, fields []zapcore.Field, ctx *withChain) bool {
+	cur := s.rules.Load()
+	if cur == nil || len(cur.list) == 0 {
+		return true
+	}
+	env := filterEnvPool.Get().(*filterEnv)
+	*env = filterEnv{logger: logger, ent: ent, fields: fields, ctx: ctx}
+	keep := true
+	for _, r := range cur.list {
+		if r.pred(env) {
+			r.matched.Add(1)
+			keep = r.Action == FilterKeep
+			break
+		}
+	}
+	*env = filterEnv{}
+	filterEnvPool.Put(env)
+	return keep
+}
+
+// needsFields reports whether a rule looks at fields
+func (s *FilterSet) needsFields() bool {
+	cur := s.rules.Load()
+	return cur != nil && cur.fields
+}
+
+func ruleName(i int, r FilterRule) string {
+	if r.Name != "" {
+		return fmt.Sprintf("%q", r.Name)
+	}
+	return fmt.Sprintf("%d", i)
+}
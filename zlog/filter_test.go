@@ -0,0 +1,257 @@
+package zlog
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type panicError struct{}
+
+func (panicError) Error() string { panic("boom") }
+
+func testFilterEnv() *filterEnv {
+	ctx := (*withChain)(nil).
+		with([]zap.Field{zap.String("tenant", "old"), zap.String("region", "eu")}).
+		with([]zap.Field{zap.String("tenant", "acme")})
+	return &filterEnv{
+		logger: "access",
+		ent:    zapcore.Entry{Level: zapcore.InfoLevel, Message: "GET /healthz/live"},
+		fields: []zap.Field{
+			zap.String("path", "/healthz/live"),
+			zap.Int("status", 503),
+			zap.Float64("delta", -1.5),
+			zap.Duration("duration", 2500*time.Millisecond),
+			zap.Bool("ok", true),
+			zap.ByteString("name", []byte(`a"b`)),
+			zap.Stringer("host", &url.URL{Host: "example.org"}),
+			zap.Error(errors.New("boom")),
+			zap.String("region", "us"),
+			zap.Inline(&AccessRecord{Method: "GET", Path: "/inline"}),
+			zap.Stringer("bad", panicStringer{}),
+			zap.NamedError("bad_err", panicError{}),
+		},
+		ctx: ctx,
+	}
+}
+
+func TestFilterExpr(t *testing.T) {
+	for expr, want := range map[string]bool{
+		// entry
+		`logger == "access"`:   true,
+		`logger == "error"`:    false,
+		`msg startsWith "GET"`: true,
+		// level names, in either case and quoted
+		`level == info`:    true,
+		`level == INFO`:    true,
+		`level < warn`:     true,
+		`level >= "error"`: false,
+		`level != debug`:   true,
+		// precedence: ! binds tightest, then &&, then ||
+		`false && false || true`:      true,
+		`true || false && false`:      true,
+		`(true || false) && false`:    false,
+		`!status == 503`:              false,
+		`!(status == 503) || ok`:      true,
+		`!ok || status > 500 && !bad`: true,
+		// literals
+		`path == "/healthz/live"`: true,
+		`name == "a\"b"`:          true,
+		`delta == -1.5`:           true,
+		`status == 5.03e2`:        true,
+		`ok == true`:              true,
+		`ok != false`:             true,
+		// field types
+		`duration >= 2`:                          true,
+		`duration < 2.5`:                         false,
+		`host == "//example.org"`:                true,
+		`name contains "\""`:                     true,
+		`error == "boom"`:                        true,
+		`path matches "^/healthz/(live|ready)$"`: true,
+		`path endsWith "live"`:                   true,
+		`status == "503"`:                        false,
+		`status != "503"`:                        true,
+		// With fields: the entry's first, then the newest With
+		`tenant == "acme"`: true,
+		`region == "us"`:   true,
+		// inline marshalers come last
+		`method == "GET"`:   true,
+		`path == "/inline"`: false,
+		// missing fields
+		`missing == "x"`: false,
+		`missing != "x"`: true,
+		`missing`:        false,
+		`!missing`:       true,
+		`missing < 1`:    false,
+		// a String or Error method that panics makes the field missing
+		`bad == ""`:      false,
+		`bad != "x"`:     true,
+		`bad_err`:        false,
+		`bad_err != "x"`: true,
+	} {
+		pred, _, err := compileFilter(expr)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		if got := pred(testFilterEnv()); got != want {
+			t.Errorf("%s = %v, want %v", expr, got, want)
+		}
+	}
+}
+
+func TestFilterExprMalformed(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`   `,
+		`status ==`,
+		`(status == 1`,
+		`status == 1)`,
+		`"open`,
+		`path matches 1`,
+		`path matches "["`,
+		`level < loud`,
+		`status # 1`,
+		`status == 1 2`,
+		`1.2.3 == 1`,
+		`&& ok`,
+		`"\q" == path`,
+	} {
+		if _, _, err := compileFilter(expr); err == nil {
+			t.Errorf("%q compiled", expr)
+		}
+	}
+}
+
+func TestFilterInlinePanics(t *testing.T) {
+	pred, _, err := compileFilter(`a == "1" && !b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &filterEnv{fields: []zap.Field{zap.Inline(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("a", "1")
+		panic("boom")
+	}))}}
+	if !pred(env) {
+		t.Fatal("fields added before the panic are not seen")
+	}
+}
+
+func TestFilterRules(t *testing.T) {
+	p, rec := Test(t, WithFilters(
+		FilterRule{Name: "keep-slow", Expr: `duration >= 2`, Action: FilterKeep},
+		FilterRule{Name: "healthz", Expr: `path startsWith "/healthz"`},
+	))
+	p.Access.Info("a", zap.String("path", "/healthz"))
+	p.Access.Info("b", zap.String("path", "/healthz"), zap.Duration("duration", 3*time.Second))
+	p.Access.Info("c", zap.String("path", "/users"))
+	if a := rec.Access(); len(a) != 2 || a[0].Message != "b" || a[1].Message != "c" {
+		t.Fatalf("access entries %v", a)
+	}
+	if r := p.Filters.Rules(); r[0].Matched != 1 || r[1].Matched != 1 {
+		t.Fatalf("rules %+v", r)
+	}
+	if err := p.Filters.Set([]FilterRule{{Expr: `(`}}); err == nil || len(p.Filters.Rules()) != 2 {
+		t.Fatalf("bad rule set: %v, rules %+v", err, p.Filters.Rules())
+	}
+}
+
+// Loggers made with With before the rules were set are filtered on their
+// With fields too, as given rather than anonymized
+func TestFilterWithFields(t *testing.T) {
+	p, rec := Test(t, WithIPAnonymization(IPAnonymizer{Fields: []string{"client"}}))
+	log := p.Access.With(zap.String("client", "203.0.113.57")).With(zap.String("tenant", "noisy"))
+	if err := p.Filters.Set([]FilterRule{{Expr: `tenant == "noisy" && client == "203.0.113.57"`}}); err != nil {
+		t.Fatal(err)
+	}
+	log.Info("dropped")
+	p.Access.Info("kept")
+	if a := rec.Access(); len(a) != 1 || a[0].Message != "kept" {
+		t.Fatalf("access entries %v", a)
+	}
+}
+
+// Entries dropped by the filter do not use up the sampling budget
+func TestFilterBeforeSampling(t *testing.T) {
+	p, rec := Test(t, WithAccessSampling(time.Hour, 2, 0), WithFilters(FilterRule{Expr: `noise`}))
+	for range 5 {
+		p.Access.Info("hot", zap.Bool("noise", true))
+	}
+	for range 3 {
+		p.Access.Info("hot")
+	}
+	if n := len(rec.Access()); n != 2 {
+		t.Fatalf("%d entries, want the 2 of the sampling budget", n)
+	}
+}
+
+// Rules on the logger, level and message apply at Check, so AccessCheck
+// reports dropped entries; rules on fields wait for the fields
+func TestFilterAtCheck(t *testing.T) {
+	p, rec := Test(t, WithAccessSampling(time.Hour, 1, 0), WithFilters(FilterRule{Expr: `msg == "noise"`}))
+	if p.AccessCheck(zapcore.InfoLevel, "noise") != nil {
+		t.Fatal("entry dropped by the filter is checked in")
+	}
+	if p.AccessCheck(zapcore.InfoLevel, "hot") == nil || p.AccessCheck(zapcore.InfoLevel, "hot") != nil {
+		t.Fatal("sampling not applied at Check")
+	}
+
+	if err := p.Filters.Set([]FilterRule{{Expr: `path == "/healthz"`}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/healthz", "/users", "/users"} {
+		ce := p.AccessCheck(zapcore.InfoLevel, "probe")
+		if ce == nil {
+			t.Fatal("field rule applied before the fields are known")
+		}
+		ce.Write(zap.String("path", path))
+	}
+	// the dropped probe did not use up the sampling budget
+	if a := rec.Access(); len(a) != 1 || a[0].Fields["path"] != "/users" {
+		t.Fatalf("access entries %v", a)
+	}
+}
+
+// AccessRecord fields are read from the record, Lazy fields are left alone
+func TestFilterRecordAndLazy(t *testing.T) {
+	p, rec := Test(t, WithFilters(
+		FilterRule{Expr: `status >= 500 || tenant == "noisy"`, Action: FilterKeep},
+		FilterRule{Expr: `path startsWith "/api" && duration < 1 || user`},
+	))
+	r := testRecord()
+	p.LogAccess(r) // dropped
+	r.Status = 503
+	p.LogAccess(r)
+	r.Status = 200
+	r.Extra = []zap.Field{zap.String("tenant", "noisy")}
+	p.LogAccess(r)
+	evaluated := false
+	p.Access.Info("lazy", Lazy("user", func() any { evaluated = true; return "root" }))
+	a := rec.Access()
+	if len(a) != 3 || a[0].Fields["status"] != 503.0 || a[1].Fields["tenant"] != "noisy" || a[2].Message != "lazy" {
+		t.Fatalf("access entries %v", a)
+	}
+	if !evaluated {
+		t.Fatal("lazy field not written")
+	}
+}
+
+func TestFilterAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable under the race detector")
+	}
+	p := benchPair(t, io.Discard, WithFilters(FilterRule{Expr: `logger=="access" && path startsWith "/healthz"`}))
+	rec := testRecord()
+	rec.Extra = []zap.Field{zap.String("tenant", "acme")}
+	for _, path := range []string{"/api/v1/users", "/healthz/ready"} {
+		rec.Path = path
+		if n := testing.AllocsPerRun(100, func() { p.LogAccess(rec) }); n != 0 {
+			t.Errorf("LogAccess of %s allocates %v times per call with a filter", path, n)
+		}
+	}
+}
@@ -0,0 +1,594 @@
+package zlog
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Filter expressions compile to closures over a filterEnv:
+//
+//	expr       = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | "(" expr ")" | comparison
+//	comparison = operand [ op operand ]
+//	op         = "==" | "!=" | "<" | "<=" | ">" | ">=" |
+//	             "startsWith" | "endsWith" | "contains" | "matches"
+//	operand    = ident | string | number | "true" | "false"
+//
+// ident is logger, level, msg or a field key. An operand alone tests that the
+// field is set and truthy.
+
+type (
+	filterPred    func(*filterEnv) bool
+	filterOperand func(*filterEnv) (filterVal, bool)
+
+	valKind int
+
+	filterVal struct {
+		kind valKind
+		s    string
+		n    float64
+		b    bool
+	}
+
+	// filterEnv is one entry being filtered, with the fields added by
+	// Logger.With in ctx. Inline marshalers (such as an AccessRecord) are only
+	// expanded if a lookup needs them.
+	filterEnv struct {
+		logger string
+		ent    zapcore.Entry
+		fields []zapcore.Field
+		ctx    *withChain
+
+		inlineDone bool
+		inline     map[string]any
+	}
+
+	tokKind int
+
+	token struct {
+		kind tokKind
+		text string
+		pos  int
+	}
+
+	exprParser struct {
+		toks []token
+		i    int
+		// fields is set once an operand names a field
+		fields bool
+	}
+)
+
+const (
+	kindString valKind = iota
+	kindNumber
+	kindBool
+)
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+var errEmptyFilter = errors.New("empty expression")
+
+// compileFilter parses and compiles an expression, reporting whether it
+// looks at fields rather than only at the logger, level and message
+func compileFilter(expr string) (_ filterPred, fields bool, _ error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, false, errEmptyFilter
+	}
+	toks, err := lexFilter(expr)
+	if err != nil {
+		return nil, false, err
+	}
+	p := &exprParser{toks: toks}
+	pred, err := p.or()
+	if err != nil {
+		return nil, false, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, false, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return pred, p.fields, nil
+}
+
+func lexFilter(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			toks = append(toks, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			toks = append(toks, token{tokRParen, ")", i})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			v, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad string at %d: %w", i, err)
+			}
+			toks = append(toks, token{tokString, v, i})
+			i = j + 1
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			j := i + 1
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' || s[j] == 'e' || s[j] == 'E') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, s[i:j], i})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || s[j] == '-' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, s[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!"} {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			toks = append(toks, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{tokEOF, "end of expression", len(s)}), nil
+}
+
+func (p *exprParser) peek() token { return p.toks[p.i] }
+
+func (p *exprParser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *exprParser) or() (filterPred, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(e *filterEnv) bool { return l(e) || right(e) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) and() (filterPred, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "&&" {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(e *filterEnv) bool { return l(e) && right(e) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) unary() (filterPred, error) {
+	switch t := p.peek(); {
+	case t.kind == tokOp && t.text == "!":
+		p.next()
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(e *filterEnv) bool { return !inner(e) }, nil
+	case t.kind == tokLParen:
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at %d", t.pos)
+		}
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+var comparisonOps = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"startsWith": true, "endsWith": true, "contains": true, "matches": true,
+}
+
+func (p *exprParser) comparison() (filterPred, error) {
+	lt := p.next()
+	left, err := p.operand(lt)
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	if (op.kind != tokOp && op.kind != tokIdent) || !comparisonOps[op.text] {
+		return func(e *filterEnv) bool {
+			v, ok := left(e)
+			return ok && v.truthy()
+		}, nil
+	}
+	p.next()
+	rt := p.next()
+
+	if op.text == "matches" {
+		if rt.kind != tokString {
+			return nil, fmt.Errorf("matches needs a string at %d", rt.pos)
+		}
+		re, err := regexp.Compile(rt.text)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern at %d: %w", rt.pos, err)
+		}
+		return func(e *filterEnv) bool {
+			v, ok := left(e)
+			return ok && v.kind == kindString && re.MatchString(v.s)
+		}, nil
+	}
+
+	// level names compare as levels: level < warn, level == "error"
+	if lt.kind == tokIdent && lt.text == "level" && (rt.kind == tokIdent || rt.kind == tokString) {
+		var lvl zapcore.Level
+		if err := lvl.UnmarshalText([]byte(rt.text)); err != nil {
+			return nil, fmt.Errorf("unknown level %q at %d", rt.text, rt.pos)
+		}
+		rt = token{tokNumber, strconv.Itoa(int(lvl)), rt.pos}
+	}
+	right, err := p.operand(rt)
+	if err != nil {
+		return nil, err
+	}
+	cmp := compareOp(op.text)
+	return func(e *filterEnv) bool {
+		l, lok := left(e)
+		r, rok := right(e)
+		if !lok || !rok {
+			return op.text == "!="
+		}
+		return cmp(l, r)
+	}, nil
+}
+
+func (p *exprParser) operand(t token) (filterOperand, error) {
+	switch t.kind {
+	case tokString:
+		v := filterVal{kind: kindString, s: t.text}
+		return func(*filterEnv) (filterVal, bool) { return v, true }, nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", t.text, t.pos)
+		}
+		v := filterVal{kind: kindNumber, n: n}
+		return func(*filterEnv) (filterVal, bool) { return v, true }, nil
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			v := filterVal{kind: kindBool, b: t.text == "true"}
+			return func(*filterEnv) (filterVal, bool) { return v, true }, nil
+		case "logger":
+			return func(e *filterEnv) (filterVal, bool) { return filterVal{kind: kindString, s: e.logger}, true }, nil
+		case "level":
+			return func(e *filterEnv) (filterVal, bool) {
+				return filterVal{kind: kindNumber, n: float64(e.ent.Level)}, true
+			}, nil
+		case "msg":
+			return func(e *filterEnv) (filterVal, bool) { return filterVal{kind: kindString, s: e.ent.Message}, true }, nil
+		}
+		key := t.text
+		p.fields = true
+		return func(e *filterEnv) (filterVal, bool) { return e.lookup(key) }, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func compareOp(op string) func(l, r filterVal) bool {
+	switch op {
+	case "==":
+		return func(l, r filterVal) bool { return l.compare(r) == 0 }
+	case "!=":
+		return func(l, r filterVal) bool { return l.compare(r) != 0 }
+	case "<":
+		return func(l, r filterVal) bool { c := l.compare(r); return c != incomparable && c < 0 }
+	case "<=":
+		return func(l, r filterVal) bool { c := l.compare(r); return c != incomparable && c <= 0 }
+	case ">":
+		return func(l, r filterVal) bool { c := l.compare(r); return c != incomparable && c > 0 }
+	case ">=":
+		return func(l, r filterVal) bool { c := l.compare(r); return c != incomparable && c >= 0 }
+	case "startsWith":
+		return func(l, r filterVal) bool {
+			return l.kind == kindString && r.kind == kindString && strings.HasPrefix(l.s, r.s)
+		}
+	case "endsWith":
+		return func(l, r filterVal) bool {
+			return l.kind == kindString && r.kind == kindString && strings.HasSuffix(l.s, r.s)
+		}
+	case "contains":
+		return func(l, r filterVal) bool {
+			return l.kind == kindString && r.kind == kindString && strings.Contains(l.s, r.s)
+		}
+	}
+	panic("zlog: unknown operator " + op)
+}
+
+// incomparable is returned by compare for values of different kinds
+const incomparable = math.MinInt
+
+func (v filterVal) compare(o filterVal) int {
+	if v.kind != o.kind {
+		return incomparable
+	}
+	switch v.kind {
+	case kindString:
+		return strings.Compare(v.s, o.s)
+	case kindNumber:
+		switch {
+		case v.n < o.n:
+			return -1
+		case v.n > o.n:
+			return 1
+		}
+		return 0
+	}
+	if v.b == o.b {
+		return 0
+	}
+	return incomparable
+}
+
+func (v filterVal) truthy() bool {
+	switch v.kind {
+	case kindString:
+		return v.s != ""
+	case kindNumber:
+		return v.n != 0
+	}
+	return v.b
+}
+
+// lookup finds a field by key: entry fields first, then the fields added by
+// With, newest first, and the fields of inline marshalers last. AccessRecords
+// are read directly; other inline marshalers are encoded, except Lazy fields,
+// which are never evaluated for the filter and count as missing.
+func (e *filterEnv) lookup(key string) (filterVal, bool) {
+	hasInline := false
+	f := findField(e.fields, key, &hasInline)
+	for c := e.ctx; f == nil && c != nil; c = c.parent {
+		f = findField(c.fields, key, &hasInline)
+	}
+	if f != nil {
+		return fieldVal(f)
+	}
+	if !hasInline {
+		return filterVal{}, false
+	}
+	other := false
+	if v, ok, found := inlineVal(e.fields, key, &other); found {
+		return v, ok
+	}
+	for c := e.ctx; c != nil; c = c.parent {
+		if v, ok, found := inlineVal(c.fields, key, &other); found {
+			return v, ok
+		}
+	}
+	if !other {
+		return filterVal{}, false
+	}
+	if !e.inlineDone {
+		e.inlineDone = true
+		// later fields win, as they would in the encoded entry
+		enc := zapcore.NewMapObjectEncoder()
+		all := append(e.ctx.appendTo(nil), e.fields...)
+		for i := range all {
+			if all[i].Type == zapcore.InlineMarshalerType && !knownInline(all[i].Interface) {
+				addInline(enc, &all[i])
+			}
+		}
+		e.inline = enc.Fields
+	}
+	v, ok := e.inline[key]
+	if !ok {
+		return filterVal{}, false
+	}
+	return anyVal(v)
+}
+
+// inlineVal looks key up in the AccessRecord, Sensitive and Lazy fields of
+// fs, last first, as the last would win in the encoded entry. found is false
+// if none of them has key; other notes any other inline marshaler.
+func inlineVal(fs []zapcore.Field, key string, other *bool) (v filterVal, ok, found bool) {
+	for i := len(fs) - 1; i >= 0; i-- {
+		if fs[i].Type != zapcore.InlineMarshalerType {
+			continue
+		}
+		switch m := fs[i].Interface.(type) {
+		case *AccessRecord:
+			if v, ok, found := accessRecordVal(m, key); found {
+				return v, ok, true
+			}
+		case *lazyField:
+			if m.key == key {
+				return filterVal{}, false, true
+			}
+		case *sensitiveValue:
+			if m.key == key {
+				return filterVal{kind: kindString, s: sensitiveMask}, true, true
+			}
+		default:
+			*other = true
+		}
+	}
+	return filterVal{}, false, false
+}
+
+func knownInline(m any) bool {
+	switch m.(type) {
+	case *AccessRecord, *lazyField, *sensitiveValue:
+		return true
+	}
+	return false
+}
+
+// accessRecordVal returns the field of r named key, as MarshalLogObject would
+// encode it; found is false if r has none
+func accessRecordVal(r *AccessRecord, key string) (v filterVal, ok, found bool) {
+	for i := len(r.Extra) - 1; i >= 0; i-- {
+		if f := &r.Extra[i]; f.Key == key && f.Type != zapcore.InlineMarshalerType {
+			v, ok = fieldVal(f)
+			return v, ok, true
+		}
+	}
+	switch key {
+	case "method":
+		return filterVal{kind: kindString, s: r.Method}, true, true
+	case "path":
+		return filterVal{kind: kindString, s: r.Path}, true, true
+	case "status":
+		return filterVal{kind: kindNumber, n: float64(r.Status)}, true, true
+	case "bytes":
+		return filterVal{kind: kindNumber, n: float64(r.Bytes)}, true, true
+	case "duration":
+		return filterVal{kind: kindNumber, n: r.Duration.Seconds()}, true, true
+	case "remote_ip":
+		return filterVal{kind: kindString, s: r.IP}, true, true
+	case "user_agent":
+		return filterVal{kind: kindString, s: r.UserAgent}, true, true
+	}
+	return filterVal{}, false, false
+}
+
+// findField returns the first field of fs named key, or nil, noting whether
+// fs has inline marshalers
+func findField(fs []zapcore.Field, key string, hasInline *bool) *zapcore.Field {
+	for i := range fs {
+		f := &fs[i]
+		if f.Type == zapcore.InlineMarshalerType {
+			*hasInline = true
+			continue
+		}
+		if f.Key == key {
+			return f
+		}
+	}
+	return nil
+}
+
+// addInline adds the fields of an inline marshaler to enc. A marshaler that
+// panics only contributes the fields it added before.
+func addInline(enc zapcore.ObjectEncoder, f *zapcore.Field) {
+	defer func() { _ = recover() }()
+	f.AddTo(enc)
+}
+
+// fieldVal returns the value of a field. A String or Error method that
+// panics makes the field missing.
+func fieldVal(f *zapcore.Field) (filterVal, bool) {
+	switch f.Type {
+	case zapcore.StringType, zapcore.ByteStringType, zapcore.StringerType:
+		s, ok := stringValue(*f)
+		return filterVal{kind: kindString, s: s}, ok
+	case zapcore.Int64Type, zapcore.Int32Type, zapcore.Int16Type, zapcore.Int8Type:
+		return filterVal{kind: kindNumber, n: float64(f.Integer)}, true
+	case zapcore.Uint64Type, zapcore.Uint32Type, zapcore.Uint16Type, zapcore.Uint8Type, zapcore.UintptrType:
+		return filterVal{kind: kindNumber, n: float64(uint64(f.Integer))}, true
+	case zapcore.Float64Type:
+		return filterVal{kind: kindNumber, n: math.Float64frombits(uint64(f.Integer))}, true
+	case zapcore.Float32Type:
+		return filterVal{kind: kindNumber, n: float64(math.Float32frombits(uint32(f.Integer)))}, true
+	case zapcore.BoolType:
+		return filterVal{kind: kindBool, b: f.Integer == 1}, true
+	case zapcore.DurationType:
+		return filterVal{kind: kindNumber, n: time.Duration(f.Integer).Seconds()}, true
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok && err != nil {
+			s, ok := safeString(err.Error)
+			return filterVal{kind: kindString, s: s}, ok
+		}
+	}
+	return filterVal{}, false
+}
+
+// safeString returns fn(), or false if it panics
+func safeString(fn func() string) (s string, ok bool) {
+	defer func() {
+		if recover() != nil {
+			s, ok = "", false
+		}
+	}()
+	return fn(), true
+}
+
+func anyVal(v any) (filterVal, bool) {
+	switch v := v.(type) {
+	case string:
+		return filterVal{kind: kindString, s: v}, true
+	case bool:
+		return filterVal{kind: kindBool, b: v}, true
+	case time.Duration:
+		return filterVal{kind: kindNumber, n: v.Seconds()}, true
+	case int:
+		return filterVal{kind: kindNumber, n: float64(v)}, true
+	case int64:
+		return filterVal{kind: kindNumber, n: float64(v)}, true
+	case int32:
+		return filterVal{kind: kindNumber, n: float64(v)}, true
+	case uint64:
+		return filterVal{kind: kindNumber, n: float64(v)}, true
+	case uint32:
+		return filterVal{kind: kindNumber, n: float64(v)}, true
+	case float64:
+		return filterVal{kind: kindNumber, n: v}, true
+	case float32:
+		return filterVal{kind: kindNumber, n: float64(v)}, true
+	case fmt.Stringer:
+		s, ok := safeString(v.String)
+		return filterVal{kind: kindString, s: s}, ok
+	}
+	return filterVal{}, false
+}
@@ -0,0 +1,319 @@
+package zlog
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// Flags holds zlog settings parsed from the command line. Build it with
+	// RegisterFlags and turn it into options with Options after parsing.
+	Flags struct {
+		AccessLevel     zapcore.Level
+		ErrorLevel      zapcore.Level
+		AccessPath      string
+		ErrorPath       string
+		MaxSize         Size
+		MaxBackups      int
+		MaxAgeDays      int
+		Compress        bool
+		ConsoleAccess   bool
+		ConsoleError    bool
+		Encoding        string
+		ConsoleEncoding string
+
+		fs     *flag.FlagSet
+		prefix string
+		set    map[string]bool // flags set through pflag
+	}
+
+	// LevelValue is a flag.Value (and pflag.Value) for a zapcore.Level
+	LevelValue struct {
+		L *zapcore.Level
+	}
+
+	// Size is a byte count that parses values such as "512KB", "100MB" or
+	// "1GiB" (units are powers of 1024). A bare number means megabytes, like
+	// the maxSizeMB argument of WithAccessFile.
+	Size int64
+
+	// GoFlagAdder is implemented by *pflag.FlagSet
+	GoFlagAdder interface {
+		AddGoFlagSet(*flag.FlagSet)
+	}
+
+	// flagValue records that a flag was set. pflag's AddGoFlagSet sets the
+	// values without parsing the go FlagSet, so FlagSet.Visit misses the
+	// flags given to pflag.
+	flagValue struct {
+		flag.Value
+		typ  string
+		name string
+		set  map[string]bool
+	}
+
+	// encodingValue is a flag.Value accepting "json" or "console"
+	encodingValue struct {
+		p *string
+	}
+)
+
+const (
+	kilobyte = 1024
+	megabyte = 1024 * kilobyte
+	gigabyte = 1024 * megabyte
+)
+
+// RegisterFlags defines the zlog flags on fs, each name starting with prefix:
+//
+//	log-level, error-log-level      access and error logger levels
+//	access-log, error-log           file paths (empty: no file)
+//	log-max-size                    rotation size, e.g. 100MB
+//	log-max-backups, log-max-age    backups kept, age in days
+//	log-compress                    gzip rotated files
+//	log-console-access, log-console-error
+//	log-encoding, log-console-encoding   "json" or "console"
+func RegisterFlags(fs *flag.FlagSet, prefix string) *Flags {
+	f := &Flags{
+		AccessLevel:     zapcore.InfoLevel,
+		ErrorLevel:      zapcore.ErrorLevel,
+		MaxSize:         100 * megabyte,
+		MaxBackups:      10,
+		MaxAgeDays:      30,
+		Compress:        true,
+		Encoding:        EncodingJSON,
+		ConsoleEncoding: EncodingJSON,
+		fs:              fs,
+		prefix:          prefix,
+		set:             map[string]bool{},
+	}
+	fs.Var(LevelValue{&f.AccessLevel}, prefix+"log-level", "access logger level")
+	fs.Var(LevelValue{&f.ErrorLevel}, prefix+"error-log-level", "error logger level")
+	fs.StringVar(&f.AccessPath, prefix+"access-log", "", "access log file (empty: no file)")
+	fs.StringVar(&f.ErrorPath, prefix+"error-log", "", "error log file (empty: no file)")
+	fs.Var(&f.MaxSize, prefix+"log-max-size", "log file size before rotation, e.g. 100MB")
+	fs.IntVar(&f.MaxBackups, prefix+"log-max-backups", f.MaxBackups, "rotated log files to keep (0: all)")
+	fs.IntVar(&f.MaxAgeDays, prefix+"log-max-age", f.MaxAgeDays, "days to keep rotated log files (0: no limit)")
+	fs.BoolVar(&f.Compress, prefix+"log-compress", f.Compress, "gzip rotated log files")
+	fs.BoolVar(&f.ConsoleAccess, prefix+"log-console-access", false, "write access logs to stdout")
+	fs.BoolVar(&f.ConsoleError, prefix+"log-console-error", false, "write error logs to stderr")
+	fs.Var(encodingValue{&f.Encoding}, prefix+"log-encoding", "log file encoding: json or console")
+	fs.Var(encodingValue{&f.ConsoleEncoding}, prefix+"log-console-encoding", "console encoding: json or console")
+	return f
+}
+
+// RegisterPFlags defines the same flags on a pflag.FlagSet
+func RegisterPFlags(fs GoFlagAdder, prefix string) *Flags {
+	gofs := flag.NewFlagSet("zlog", flag.ContinueOnError)
+	f := RegisterFlags(gofs, prefix)
+	gofs.VisitAll(func(fl *flag.Flag) {
+		fl.Value = &flagValue{Value: fl.Value, typ: flagType(fl.Value), name: fl.Name, set: f.set}
+	})
+	fs.AddGoFlagSet(gofs)
+	return f
+}
+
+// Options converts the flags into options. Only settings given on the
+// command line are emitted (files are emitted when their path is set), so
+// the result can be appended after a preset without resetting it.
+//
+// Rotation flags given without a path apply to the files configured by the
+// options before them, e.g. a preset's.
+func (f *Flags) Options() []Option {
+	set := map[string]bool{}
+	mark := func(name string) { set[strings.TrimPrefix(name, f.prefix)] = true }
+	f.fs.Visit(func(fl *flag.Flag) { mark(fl.Name) })
+	for name := range f.set {
+		mark(name)
+	}
+	sizeMB := int((f.MaxSize + megabyte - 1) / megabyte)
+	var opts []Option
+	if f.AccessPath != "" {
+		opts = append(opts, WithAccessFile(f.AccessPath, sizeMB, f.MaxBackups, f.MaxAgeDays, f.Compress))
+	}
+	if f.ErrorPath != "" {
+		opts = append(opts, WithErrorFile(f.ErrorPath, sizeMB, f.MaxBackups, f.MaxAgeDays, f.Compress))
+	}
+	if set["log-max-size"] || set["log-max-backups"] || set["log-max-age"] || set["log-compress"] {
+		opts = append(opts, f.rotation(set))
+	}
+	// each half of WithInitialLevels and WithEncoding is emitted on its own,
+	// so the other half keeps the value of a preset
+	if set["log-level"] {
+		lvl := f.AccessLevel
+		opts = append(opts, func(c *buildCfg) { c.initialAccessLevel = lvl })
+	}
+	if set["error-log-level"] {
+		lvl := f.ErrorLevel
+		opts = append(opts, func(c *buildCfg) { c.initialErrorLevel = lvl })
+	}
+	if set["log-console-access"] {
+		opts = append(opts, WithConsoleForAccess(f.ConsoleAccess))
+	}
+	if set["log-console-error"] {
+		opts = append(opts, WithConsoleForError(f.ConsoleError))
+	}
+	if set["log-encoding"] {
+		opts = append(opts, encodingOption(f.Encoding, func(c *buildCfg) *string { return &c.fileEncoding }))
+	}
+	if set["log-console-encoding"] {
+		opts = append(opts, encodingOption(f.ConsoleEncoding, func(c *buildCfg) *string { return &c.consoleEncoding }))
+	}
+	return opts
+}
+
+// rotation applies the rotation flags in set to every file sink configured
+// so far
+func (f *Flags) rotation(set map[string]bool) Option {
+	sizeMB := int((f.MaxSize + megabyte - 1) / megabyte)
+	apply := func(r *rotateCfg) {
+		if r.Path == "" {
+			return
+		}
+		if set["log-max-size"] {
+			r.MaxSizeMB = sizeMB
+		}
+		if set["log-max-backups"] {
+			r.MaxBackups = f.MaxBackups
+		}
+		if set["log-max-age"] {
+			r.MaxAgeDays = f.MaxAgeDays
+		}
+		if set["log-compress"] {
+			r.Compress = f.Compress
+		}
+	}
+	return func(c *buildCfg) {
+		apply(&c.access)
+		apply(&c.error)
+		for i := range c.errorRoutes {
+			apply(&c.errorRoutes[i].file)
+		}
+	}
+}
+
+// encodingOption sets the encoding field returns to enc, checking it as
+// WithEncoding does
+func encodingOption(enc string, field func(*buildCfg) *string) Option {
+	return func(c *buildCfg) {
+		if err := checkEncoding(enc); err != nil {
+			c.errs = append(c.errs, err)
+			return
+		}
+		*field(c) = enc
+	}
+}
+
+// flagType names the type of a flag value for pflag's help output: the
+// result of its Type method, or "int", "bool", ... for the values of the flag
+// package (*flag.intValue, *flag.boolValue, ...)
+func flagType(v flag.Value) string {
+	if t, ok := v.(interface{ Type() string }); ok {
+		return t.Type()
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(fmt.Sprintf("%T", v), "*flag."), "Value")
+}
+
+func (v *flagValue) Set(s string) error {
+	if err := v.Value.Set(s); err != nil {
+		return err
+	}
+	v.set[v.name] = true
+	return nil
+}
+
+// Type implements pflag.Value
+func (v *flagValue) Type() string { return v.typ }
+
+// IsBoolFlag lets boolean flags be given without a value
+func (v *flagValue) IsBoolFlag() bool {
+	b, ok := v.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func (v encodingValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v encodingValue) Set(s string) error {
+	if err := checkEncoding(s); err != nil {
+		return err
+	}
+	*v.p = s
+	return nil
+}
+
+// Type implements pflag.Value
+func (encodingValue) Type() string { return "encoding" }
+
+func (v LevelValue) String() string {
+	if v.L == nil {
+		return ""
+	}
+	return v.L.String()
+}
+
+func (v LevelValue) Set(s string) error {
+	return v.L.UnmarshalText([]byte(s))
+}
+
+// Type implements pflag.Value
+func (LevelValue) Type() string { return "level" }
+
+// ParseSize parses a size such as "100MB"; see Size
+func ParseSize(s string) (Size, error) {
+	num := strings.TrimSpace(s)
+	mult := int64(megabyte)
+	upper := strings.ToUpper(num)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KIB", kilobyte}, {"MIB", megabyte}, {"GIB", gigabyte},
+		{"KB", kilobyte}, {"MB", megabyte}, {"GB", gigabyte},
+		{"K", kilobyte}, {"M", megabyte}, {"G", gigabyte},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(upper, u.suffix) {
+			num = strings.TrimSpace(num[:len(num)-len(u.suffix)])
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("zlog: invalid size %q", s)
+	}
+	return Size(n * float64(mult)), nil
+}
+
+func (s Size) String() string {
+	switch {
+	case s >= gigabyte && s%gigabyte == 0:
+		return strconv.FormatInt(int64(s/gigabyte), 10) + "GB"
+	case s >= megabyte && s%megabyte == 0:
+		return strconv.FormatInt(int64(s/megabyte), 10) + "MB"
+	case s >= kilobyte && s%kilobyte == 0:
+		return strconv.FormatInt(int64(s/kilobyte), 10) + "KB"
+	}
+	return strconv.FormatInt(int64(s), 10) + "B"
+}
+
+func (s *Size) Set(v string) error {
+	n, err := ParseSize(v)
+	if err != nil {
+		return err
+	}
+	*s = n
+	return nil
+}
+
+// Type implements pflag.Value
+func (*Size) Type() string { return "size" }
@@ -0,0 +1,179 @@
+package zlog
+
+import (
+	"flag"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"go.uber.org/zap/zapcore"
+)
+
+// parsers register the zlog flags with a prefix and parse args through the
+// standard flag package or through pflag
+var parsers = map[string]func(t *testing.T, args []string) (*Flags, error){
+	"flag": func(t *testing.T, args []string) (*Flags, error) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		f := RegisterFlags(fs, "app-")
+		return f, fs.Parse(args)
+	},
+	"pflag": func(t *testing.T, args []string) (*Flags, error) {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		f := RegisterPFlags(fs, "app-")
+		return f, fs.Parse(args)
+	},
+}
+
+func TestFlagsOptions(t *testing.T) {
+	for name, parse := range parsers {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			f, err := parse(t, []string{
+				"--app-log-level=debug",
+				"--app-access-log=" + filepath.Join(dir, "access.log"),
+				"--app-log-max-size=2MB",
+				"--app-log-console-access",
+				"--app-log-encoding=console",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cfg buildCfg
+			for _, o := range f.Options() {
+				o(&cfg)
+			}
+			if cfg.initialAccessLevel != zapcore.DebugLevel {
+				t.Errorf("access level = %v", cfg.initialAccessLevel)
+			}
+			if cfg.access.Path == "" || cfg.access.MaxSizeMB != 2 || cfg.access.MaxBackups != 10 {
+				t.Errorf("access file = %+v", cfg.access)
+			}
+			if !cfg.consoleStdout || cfg.consoleStderr {
+				t.Errorf("consoles = %v, %v", cfg.consoleStdout, cfg.consoleStderr)
+			}
+			if cfg.fileEncoding != EncodingConsole {
+				t.Errorf("file encoding = %q", cfg.fileEncoding)
+			}
+		})
+	}
+}
+
+func TestFlagsUnsetEmitNothing(t *testing.T) {
+	for name, parse := range parsers {
+		t.Run(name, func(t *testing.T) {
+			f, err := parse(t, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts := f.Options(); len(opts) != 0 {
+				t.Fatalf("%d options without flags, want 0", len(opts))
+			}
+		})
+	}
+}
+
+func TestFlagsRotationOverridesPreset(t *testing.T) {
+	for name, parse := range parsers {
+		t.Run(name, func(t *testing.T) {
+			f, err := parse(t, []string{"--app-log-max-age=3", "--app-log-compress=false"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := buildCfg{}
+			preset := []Option{
+				WithAccessFile("/var/log/app/access.log", 100, 10, 30, true),
+				WithErrorRoute(zapcore.WarnLevel, zapcore.WarnLevel, "/var/log/app/warn.log", 50, 5, 7, true),
+			}
+			for _, o := range append(preset, f.Options()...) {
+				o(&cfg)
+			}
+			for _, r := range []rotateCfg{cfg.access, cfg.errorRoutes[0].file} {
+				if r.MaxAgeDays != 3 || r.Compress {
+					t.Errorf("%s: age=%d compress=%v, want 3 and false", r.Path, r.MaxAgeDays, r.Compress)
+				}
+			}
+			// flags not given leave the preset's values alone
+			if cfg.access.MaxSizeMB != 100 || cfg.errorRoutes[0].file.MaxSizeMB != 50 {
+				t.Errorf("sizes = %d, %d", cfg.access.MaxSizeMB, cfg.errorRoutes[0].file.MaxSizeMB)
+			}
+			if cfg.error.Path != "" {
+				t.Errorf("rotation flags created an error file: %+v", cfg.error)
+			}
+		})
+	}
+}
+
+// A level or encoding flag given alone leaves the other one of its pair as
+// the preset set it
+func TestFlagsHalfPairOverridesPreset(t *testing.T) {
+	for name, parse := range parsers {
+		t.Run(name, func(t *testing.T) {
+			f, err := parse(t, []string{"--app-error-log-level=debug", "--app-log-encoding=console"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := buildCfg{}
+			preset := []Option{
+				WithInitialLevels(zapcore.WarnLevel, zapcore.WarnLevel),
+				WithEncoding(EncodingJSON, EncodingConsole),
+			}
+			for _, o := range append(preset, f.Options()...) {
+				o(&cfg)
+			}
+			if cfg.initialAccessLevel != zapcore.WarnLevel || cfg.initialErrorLevel != zapcore.DebugLevel {
+				t.Errorf("levels = %v, %v, want warn, debug", cfg.initialAccessLevel, cfg.initialErrorLevel)
+			}
+			if cfg.fileEncoding != EncodingConsole || cfg.consoleEncoding != EncodingConsole {
+				t.Errorf("encodings = %q, %q, want console, console", cfg.fileEncoding, cfg.consoleEncoding)
+			}
+			if len(cfg.errs) != 0 {
+				t.Errorf("errors: %v", cfg.errs)
+			}
+		})
+	}
+}
+
+func TestFlagsRejectUnknownEncoding(t *testing.T) {
+	for name, parse := range parsers {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parse(t, []string{"--app-log-encoding=yaml"}); err == nil {
+				t.Fatal("unknown encoding accepted")
+			}
+		})
+	}
+}
+
+func TestWithEncodingRejectsUnknown(t *testing.T) {
+	if p, err := New(WithEncoding("json", "text")); err == nil {
+		p.Close()
+		t.Fatal("New accepted an unknown encoding")
+	}
+	p, err := New(WithEncoding(EncodingConsole, EncodingJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+}
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]Size{
+		"100":    100 * megabyte,
+		"512KB":  512 * kilobyte,
+		"1GiB":   gigabyte,
+		"1.5 mb": 3 * megabyte / 2,
+		"10b":    10,
+	} {
+		got, err := ParseSize(in)
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "MB", "-1MB", "ten"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) accepted", in)
+		}
+	}
+}
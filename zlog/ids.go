@@ -0,0 +1,105 @@
+package zlog
+
+import (
+	"crypto/rand"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// entryStamper adds the sequence number, and the ULID, to each entry of a
+	// logger
+	entryStamper struct {
+		ids  *entryIDs
+		ulid bool
+
+		seqKey, idKey string
+	}
+
+	// entryIDs generates process-wide sequence numbers and monotonic ULIDs.
+	// Both are taken under one lock when ULIDs are on, so their orders agree.
+	entryIDs struct {
+		seq atomic.Uint64
+
+		mu     sync.Mutex
+		lastMs uint64
+		random [10]byte
+	}
+)
+
+// Default keys of WithSequence, see WithSequenceKeys
+const (
+	defaultSeqKey     = "seq"
+	defaultEntryIDKey = "entry_id"
+)
+
+// crockford is the ULID alphabet
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// processIDs is shared by every Pair of the process
+var processIDs entryIDs
+
+// stamp returns fields with the entry's sequence number (and ULID) appended,
+// in buf
+func (s *entryStamper) stamp(buf *entryBuf, ent zapcore.Entry, fields []zapcore.Field) []zapcore.Field {
+	out := buf.own(fields)
+	if !s.ulid {
+		out = append(out, zap.Uint64(s.seqKey, s.ids.seq.Add(1)))
+	} else {
+		seq, id := s.ids.next(uint64(ent.Time.UnixMilli()))
+		out = append(out, zap.Uint64(s.seqKey, seq), zap.String(s.idKey, id))
+	}
+	buf.fields = out
+	return out
+}
+
+// next returns a sequence number and a ULID greater than every ULID returned
+// before, even when ms goes backwards
+func (g *entryIDs) next(ms uint64) (uint64, string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	seq := g.seq.Add(1)
+	if ms > g.lastMs {
+		g.lastMs = ms
+		_, _ = rand.Read(g.random[:])
+		// keep room for increments within the millisecond
+		g.random[0] &= 0x7f
+	} else {
+		for i := len(g.random) - 1; i >= 0; i-- {
+			g.random[i]++
+			if g.random[i] != 0 {
+				break
+			}
+		}
+	}
+	return seq, encodeULID(g.lastMs, g.random)
+}
+
+// encodeULID renders a 48-bit millisecond timestamp and 80 random bits as 26
+// Crockford base32 characters
+func encodeULID(ms uint64, random [10]byte) string {
+	var id [16]byte
+	id[0] = byte(ms >> 40)
+	id[1] = byte(ms >> 32)
+	id[2] = byte(ms >> 24)
+	id[3] = byte(ms >> 16)
+	id[4] = byte(ms >> 8)
+	id[5] = byte(ms)
+	copy(id[6:], random[:])
+
+	// 128 bits as 26 5-bit groups, the first group holding the top 3 bits
+	var out [26]byte
+	hi := uint64(id[0])<<56 | uint64(id[1])<<48 | uint64(id[2])<<40 | uint64(id[3])<<32 |
+		uint64(id[4])<<24 | uint64(id[5])<<16 | uint64(id[6])<<8 | uint64(id[7])
+	lo := uint64(id[8])<<56 | uint64(id[9])<<48 | uint64(id[10])<<40 | uint64(id[11])<<32 |
+		uint64(id[12])<<24 | uint64(id[13])<<16 | uint64(id[14])<<8 | uint64(id[15])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
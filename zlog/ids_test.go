@@ -0,0 +1,54 @@
+package zlog
+
+import "testing"
+
+func TestSequence(t *testing.T) {
+	p, rec := Test(t, WithSequence(true))
+	p.Access.Info("a")
+	p.Error.Error("b")
+	p.Access.Info("c")
+	a1, a2, e := rec.Access()[0].Fields, rec.Access()[1].Fields, rec.Error()[0].Fields
+	if !(a1["seq"].(float64) < e["seq"].(float64) && e["seq"].(float64) < a2["seq"].(float64)) {
+		t.Fatalf("seq not shared in write order: %v %v %v", a1["seq"], e["seq"], a2["seq"])
+	}
+	if id1, id2 := a1["entry_id"].(string), e["entry_id"].(string); len(id1) != 26 || id1 >= id2 {
+		t.Fatalf("entry_id %q then %q", id1, id2)
+	}
+}
+
+func TestSequenceKeys(t *testing.T) {
+	p, rec := Test(t, WithSequenceKeys("log_seq", "log_id"), WithSequence(true))
+	p.Access.Info("a")
+	f := rec.Access()[0].Fields
+	if _, ok := f["log_seq"].(float64); !ok {
+		t.Errorf("no log_seq: %v", f)
+	}
+	if _, ok := f["log_id"].(string); !ok {
+		t.Errorf("no log_id: %v", f)
+	}
+	if _, ok := f["seq"]; ok {
+		t.Errorf("default key written: %v", f)
+	}
+
+	for _, keys := range [][2]string{{"", "id"}, {"seq", ""}, {"k", "k"}} {
+		if _, err := New(WithSequence(false), WithSequenceKeys(keys[0], keys[1])); err == nil {
+			t.Errorf("keys %q accepted", keys)
+		}
+	}
+}
+
+// ULIDs keep increasing when the clock stands still or goes back
+func TestEntryIDsMonotonic(t *testing.T) {
+	var ids entryIDs
+	prevSeq, prev := ids.next(1000)
+	for _, ms := range []uint64{1000, 999, 1001, 1001} {
+		seq, id := ids.next(ms)
+		if seq != prevSeq+1 || id <= prev {
+			t.Fatalf("ms %d: %d %q after %d %q", ms, seq, id, prevSeq, prev)
+		}
+		prevSeq, prev = seq, id
+	}
+	if got := encodeULID(0, [10]byte{}); got != "00000000000000000000000000" {
+		t.Fatalf("zero ULID %q", got)
+	}
+}
@@ -0,0 +1,60 @@
+package zlog
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// lazyField computes its value once, the first time a sink encodes it
+type lazyField struct {
+	key  string
+	fn   func() zap.Field
+	once sync.Once
+	f    zap.Field
+}
+
+// Lazy returns a field whose value is computed by fn only when the entry is
+// actually encoded, i.e. after the level, sampling and sink checks passed.
+// fn runs at most once per field even if several sinks write the entry.
+// Fields given to Logger.With are encoded, and thus evaluated, immediately.
+func Lazy(key string, fn func() any) zap.Field {
+	return lazy(key, func() zap.Field { return zap.Any(key, fn()) })
+}
+
+// LazyObject is Lazy for a zapcore.ObjectMarshaler
+func LazyObject(key string, fn func() zapcore.ObjectMarshaler) zap.Field {
+	return lazy(key, func() zap.Field { return zap.Object(key, fn()) })
+}
+
+func lazy(key string, fn func() zap.Field) zap.Field {
+	return zap.Field{Key: key, Type: zapcore.InlineMarshalerType, Interface: &lazyField{key: key, fn: fn}}
+}
+
+// MarshalLogObject adds the computed field to the enclosing object
+func (l *lazyField) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	l.once.Do(func() { l.f = l.fn() })
+	l.f.AddTo(enc)
+	return nil
+}
+
+// AccessEnabled reports whether an access entry at lvl would be written by at
+// least one sink, given the logger level and every sink level. It does not
+// consume a sampling slot; use AccessCheck when sampling matters.
+func (p *Pair) AccessEnabled(lvl zapcore.Level) bool {
+	return p.Access.Core().Enabled(lvl)
+}
+
+// AccessCheck returns a CheckedEntry if an access entry with this level and
+// message would be written, taking sampling into account, or nil otherwise.
+// While a filter rule looks at fields, filtering and sampling wait for the
+// fields, and the entry may still be dropped when written. Write the fields
+// with ce.Write:
+//
+//	if ce := pair.AccessCheck(zapcore.DebugLevel, "request"); ce != nil {
+//		ce.Write(zap.Any("headers", r.Header))
+//	}
+func (p *Pair) AccessCheck(lvl zapcore.Level, msg string) *zapcore.CheckedEntry {
+	return p.Access.Check(lvl, msg)
+}
@@ -0,0 +1,94 @@
+package zlog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLazyEvaluatedOnce(t *testing.T) {
+	var a, b bytes.Buffer
+	p, err := New(
+		WithAccessConsole(&a, zapcore.DebugLevel, zapcore.FatalLevel),
+		WithAccessConsole(&b, zapcore.DebugLevel, zapcore.FatalLevel),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	calls := 0
+	p.Access.Info("request", Lazy("headers", func() any { calls++; return "h" }))
+	if calls != 1 {
+		t.Fatalf("fn ran %d times for two sinks, want 1", calls)
+	}
+	for _, out := range []string{a.String(), b.String()} {
+		if !strings.Contains(out, `"headers":"h"`) {
+			t.Errorf("sink output %q lacks the lazy field", out)
+		}
+	}
+}
+
+func TestLazyNotEvaluatedWhenDisabled(t *testing.T) {
+	var out bytes.Buffer
+	p, err := New(
+		WithAccessConsole(&out, zapcore.DebugLevel, zapcore.FatalLevel),
+		WithInitialLevels(zapcore.InfoLevel, zapcore.ErrorLevel),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Access.Debug("request", Lazy("headers", func() any { t.Error("fn ran for a disabled level"); return nil }))
+	if p.AccessEnabled(zapcore.DebugLevel) || !p.AccessEnabled(zapcore.InfoLevel) {
+		t.Error("AccessEnabled disagrees with the access level")
+	}
+	if out.Len() != 0 {
+		t.Errorf("disabled entry written: %q", out.String())
+	}
+}
+
+func TestLazyObject(t *testing.T) {
+	p, rec := Test(t)
+	p.Access.Info("request", LazyObject("session", func() zapcore.ObjectMarshaler {
+		return zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("user", "ada")
+			return nil
+		})
+	}))
+	e := rec.Access()[0]
+	if s, ok := e.Fields["session"].(map[string]any); !ok || s["user"] != "ada" {
+		t.Fatalf("session = %v", e.Fields["session"])
+	}
+}
+
+func TestAccessCheckSampling(t *testing.T) {
+	var out bytes.Buffer
+	p, err := New(
+		WithAccessConsole(&out, zapcore.DebugLevel, zapcore.FatalLevel),
+		WithAccessSampling(time.Hour, 2, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	written := 0
+	for range 5 {
+		if ce := p.AccessCheck(zapcore.InfoLevel, "hot"); ce != nil {
+			ce.Write(zap.Int("n", written))
+			written++
+		}
+	}
+	if written != 2 {
+		t.Fatalf("AccessCheck let %d entries through, want the 2 of the sampling budget", written)
+	}
+	if p.AccessCheck(zapcore.DebugLevel, "cold") != nil {
+		t.Fatal("AccessCheck returned an entry for a disabled level")
+	}
+}
@@ -0,0 +1,42 @@
+package zlog
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// levelRange enables levels in [min, max]
+	levelRange struct {
+		min, max zapcore.Level
+	}
+
+	// allLevels enables a level only if every enabler does
+	allLevels []zapcore.LevelEnabler
+)
+
+func (r levelRange) Enabled(l zapcore.Level) bool {
+	return l >= r.min && l <= r.max
+}
+
+func (a allLevels) Enabled(l zapcore.Level) bool {
+	for _, e := range a {
+		if !e.Enabled(l) {
+			return false
+		}
+	}
+	return true
+}
+
+// andLevels combines enablers, skipping nil ones
+func andLevels(enablers ...zapcore.LevelEnabler) zapcore.LevelEnabler {
+	var all allLevels
+	for _, e := range enablers {
+		if e != nil {
+			all = append(all, e)
+		}
+	}
+	if len(all) == 1 {
+		return all[0]
+	}
+	return all
+}
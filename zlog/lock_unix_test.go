@@ -0,0 +1,36 @@
+//go:build unix
+
+package zlog
+
+import (
+	"testing"
+	"time"
+)
+
+// Erase waits for the cleanup of a running sink, which holds the same lock
+func TestEraseWaitsForLock(t *testing.T) {
+	path := eraseFixture(t)
+	unlock, err := lockFile(path + lockSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := Erase(EraseOptions{Path: path, Field: "user_id", Value: "42"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Erase ran while the lock was held: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Erase did not run after the lock was released")
+	}
+}
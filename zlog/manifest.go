@@ -0,0 +1,467 @@
+package zlog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// ManifestEntry describes one rotated backup. Size, Entries and SHA256 cover
+	// the uncompressed contents, so an entry stays valid after the backup is
+	// gzipped. First and Last are the wall-clock times the first and last
+	// entries were written. For entries left by a previous run they are read
+	// from the entries' timestamps, or taken from the file's modification
+	// time when the timestamps cannot be parsed.
+	ManifestEntry struct {
+		Name    string    `json:"name"`
+		Size    int64     `json:"size"`
+		First   time.Time `json:"first"`
+		Last    time.Time `json:"last"`
+		Entries int64     `json:"entries"`
+		SHA256  string    `json:"sha256"`
+	}
+
+	// ChecksumError reports a backup whose contents do not match its manifest entry
+	ChecksumError struct {
+		Name string
+		Want string
+		Got  string
+	}
+
+	// segment accumulates manifest data for the active file: exactly the
+	// bytes that will end up in its backup
+	segment struct {
+		size    int64
+		entries int64
+		first   time.Time
+		last    time.Time
+		sum     hash.Hash
+	}
+)
+
+const (
+	manifestSuffix = ".manifest"
+	compressSuffix = ".gz"
+	// lockSuffix names the file locked while backups are rewritten, by the
+	// cleanup of a sink or by Erase
+	lockSuffix = ".lock"
+)
+
+// backupTimeFormat is the timestamp layout of backup names, as in lumberjack
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+func (e *ChecksumError) Error() string {
+	if e.Got == "" {
+		return fmt.Sprintf("zlog: backup %s is missing", e.Name)
+	}
+	return fmt.Sprintf("zlog: backup %s checksum mismatch: manifest %s, file %s", e.Name, e.Want, e.Got)
+}
+
+// Covers reports whether the backup holds entries written within [from, to]
+func (e ManifestEntry) Covers(from, to time.Time) bool {
+	return !e.Last.Before(from) && !e.First.After(to)
+}
+
+// ManifestPath returns the manifest file maintained for the log file at path
+func ManifestPath(path string) string {
+	return path + manifestSuffix
+}
+
+// ReadManifest loads the entries of a manifest file, oldest first
+func ReadManifest(manifestPath string) ([]ManifestEntry, error) {
+	f, err := os.Open(manifestPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []ManifestEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e ManifestEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("zlog: manifest %s: %w", manifestPath, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// VerifyManifest recomputes the checksum of every backup listed in the manifest.
+// It returns the entries that failed; the error joins a *ChecksumError per failure.
+func VerifyManifest(manifestPath string) ([]ManifestEntry, error) {
+	entries, err := ReadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(manifestPath)
+
+	var (
+		bad  []ManifestEntry
+		errs []error
+	)
+	for _, e := range entries {
+		got, err := backupChecksum(filepath.Join(dir, e.Name))
+		if err != nil {
+			return bad, err
+		}
+		if got != e.SHA256 {
+			bad = append(bad, e)
+			errs = append(errs, &ChecksumError{Name: e.Name, Want: e.SHA256, Got: got})
+		}
+	}
+	return bad, errors.Join(errs...)
+}
+
+// backupChecksum hashes the uncompressed contents of a backup, reading the
+// gzipped copy if the plain one is gone. A missing backup yields "".
+func backupChecksum(name string) (string, error) {
+	r, err := openBackup(name)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// openBackup opens name, falling back to name.gz and decompressing it
+func openBackup(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err = os.Open(name + compressSuffix)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, f}, nil
+}
+
+// maxBytes mirrors lumberjack: a zero size means 100 megabytes
+func maxBytes(sizeMB int) int64 {
+	if sizeMB == 0 {
+		sizeMB = 100
+	}
+	return int64(sizeMB) * 1024 * 1024
+}
+
+func newSegment() *segment {
+	return &segment{sum: sha256.New()}
+}
+
+// load accounts for what a previous run left in the active file. timeOf,
+// if set, reads the times of its first and last entries.
+func (s *segment) load(path string, timeOf func([]byte) (time.Time, bool)) error {
+	s.reset()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var lines lineCounter
+	n, err := io.Copy(io.MultiWriter(s.sum, &lines), f)
+	if err != nil {
+		return err
+	}
+	s.size, s.entries = n, lines.count()
+	if s.size > 0 {
+		s.first, s.last = info.ModTime(), info.ModTime()
+		if timeOf != nil {
+			if t, ok := timeOf(lines.head()); ok {
+				s.first = t
+			}
+			if t, ok := timeOf(lines.last()); ok {
+				s.last = t
+			}
+		}
+	}
+	return nil
+}
+
+// lineHead is how much of a line lineCounter keeps: enough for the time of
+// an entry, which encoders write first
+const lineHead = 4 << 10
+
+// lineCounter counts the lines written to it, the last one possibly
+// unterminated, and keeps the head of the first and of the last line
+type lineCounter struct {
+	lines  int64
+	first  []byte
+	prev   []byte // head of the last terminated line
+	cur    []byte // head of the line being written
+	inLine bool   // bytes were written since the last newline
+}
+
+func (l *lineCounter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk, rest, eol := bytes.Cut(p, []byte{'\n'})
+		if room := lineHead - len(l.cur); room > 0 {
+			l.cur = append(l.cur, chunk[:min(room, len(chunk))]...)
+		}
+		if !eol {
+			l.inLine = true
+			break
+		}
+		l.lines++
+		if l.lines == 1 {
+			l.first = append([]byte(nil), l.cur...)
+		}
+		l.prev, l.cur = l.cur, l.prev[:0]
+		l.inLine = false
+		p = rest
+	}
+	return n, nil
+}
+
+func (l *lineCounter) count() int64 {
+	if l.inLine {
+		return l.lines + 1
+	}
+	return l.lines
+}
+
+func (l *lineCounter) head() []byte {
+	if l.lines == 0 {
+		return l.cur
+	}
+	return l.first
+}
+
+func (l *lineCounter) last() []byte {
+	if l.inLine {
+		return l.cur
+	}
+	return l.prev
+}
+
+// entryTimeFunc reads the time of an encoded entry: the timeKey field of
+// JSON entries, or the first column of console entries. It understands the
+// ISO8601, RFC3339 and epoch time encoders of zapcore.
+func entryTimeFunc(encoding, timeKey string) func([]byte) (time.Time, bool) {
+	if timeKey == "" {
+		return nil
+	}
+	if encoding == EncodingConsole {
+		return func(line []byte) (time.Time, bool) {
+			col, _, _ := bytes.Cut(line, []byte{'\t'})
+			return parseEntryTime(col)
+		}
+	}
+	return func(line []byte) (time.Time, bool) {
+		raw, ok := jsonField(line, timeKey)
+		if !ok {
+			return time.Time{}, false
+		}
+		if len(raw) > 0 && raw[0] == '"' {
+			var s string
+			if json.Unmarshal(raw, &s) != nil {
+				return time.Time{}, false
+			}
+			return parseEntryTime([]byte(s))
+		}
+		return parseEntryTime(raw)
+	}
+}
+
+// entryTimeLayouts are the layouts of zapcore's string time encoders
+var entryTimeLayouts = []string{
+	"2006-01-02T15:04:05.000Z0700", // ISO8601TimeEncoder
+	time.RFC3339Nano,
+	time.RFC3339,
+}
+
+// parseEntryTime parses a timestamp in one of entryTimeLayouts, or a number
+// of seconds, milliseconds or nanoseconds since the epoch
+func parseEntryTime(b []byte) (time.Time, bool) {
+	s := string(bytes.TrimSpace(b))
+	for _, layout := range entryTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return time.Time{}, false
+	}
+	switch {
+	case f >= 1e17:
+		return time.Unix(0, int64(f)), true
+	case f >= 1e11:
+		return time.UnixMilli(int64(f)), true
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), true
+}
+
+// jsonField returns the raw value of a top-level field of a JSON object. It
+// stops at the field, so it works on a truncated line too.
+func jsonField(line []byte, key string) (json.RawMessage, bool) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, false
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, false
+		}
+		if k, _ := t.(string); k == key {
+			return raw, true
+		}
+	}
+	return nil, false
+}
+
+func (s *segment) add(b []byte, entries int) {
+	now := time.Now()
+	if s.entries == 0 {
+		s.first = now
+	}
+	s.last = now
+	s.entries += int64(entries)
+	s.size += int64(len(b))
+	s.sum.Write(b)
+}
+
+func (s *segment) entry(name string) ManifestEntry {
+	return ManifestEntry{
+		Name:    name,
+		Size:    s.size,
+		First:   s.first.UTC(),
+		Last:    s.last.UTC(),
+		Entries: s.entries,
+		SHA256:  hex.EncodeToString(s.sum.Sum(nil)),
+	}
+}
+
+func (s *segment) reset() {
+	s.size, s.entries = 0, 0
+	s.first, s.last = time.Time{}, time.Time{}
+	s.sum.Reset()
+}
+
+// writeManifest atomically replaces the manifest, dropping entries whose
+// backups have since been removed by MaxBackups or MaxAgeDays
+func writeManifest(manifestPath string, entries []ManifestEntry) error {
+	dir := filepath.Dir(manifestPath)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if !backupExists(filepath.Join(dir, e.Name)) {
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	tmp := manifestPath + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, manifestPath)
+}
+
+func backupExists(name string) bool {
+	if _, err := os.Stat(name); err == nil {
+		return true
+	}
+	_, err := os.Stat(name + compressSuffix)
+	return err == nil
+}
+
+type backupFile struct {
+	name string // uncompressed backup name, relative to the log directory
+	time time.Time
+	gz   bool
+}
+
+// listBackups finds the rotated backups of path, oldest first. local tells
+// whether backup timestamps are in local time rather than UTC.
+func listBackups(path string, local bool) ([]backupFile, error) {
+	dir := filepath.Dir(path)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	prefix := base[:len(base)-len(ext)] + "-"
+
+	var backups []backupFile
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		name := f.Name()
+		gz := strings.HasSuffix(name, compressSuffix)
+		plain := strings.TrimSuffix(name, compressSuffix)
+		if seen[plain] || !strings.HasPrefix(plain, prefix) || !strings.HasSuffix(plain, ext) {
+			continue
+		}
+		ts := plain[len(prefix) : len(plain)-len(ext)]
+		loc := time.UTC
+		if local {
+			loc = time.Local
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, ts, loc)
+		if err != nil {
+			continue
+		}
+		seen[plain] = true
+		backups = append(backups, backupFile{name: plain, time: t, gz: gz})
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].time.Before(backups[j].time)
+	})
+	return backups, nil
+}
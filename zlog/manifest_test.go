@@ -0,0 +1,170 @@
+package zlog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManifestOnRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w := newFileRotator(rotateCfg{Path: path, Manifest: true})
+	for _, line := range []string{"a\n", "bb\n", "ccc\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadManifest(ManifestPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d manifest entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Entries != 3 || e.Size != 9 {
+		t.Fatalf("entries=%d size=%d, want 3 and 9", e.Entries, e.Size)
+	}
+	if e.First.IsZero() || e.Last.Before(e.First) {
+		t.Fatalf("first=%v last=%v", e.First, e.Last)
+	}
+	if bad, err := VerifyManifest(ManifestPath(path)); err != nil || len(bad) != 0 {
+		t.Fatalf("VerifyManifest = %v, %v", bad, err)
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		mutate  func(backup string) error
+		missing bool
+	}{
+		{name: "ok"},
+		{name: "gzipped", mutate: compressFile},
+		{name: "tampered", mutate: func(b string) error { return os.WriteFile(b, []byte("forged\n"), 0o600) }},
+		{name: "missing", mutate: os.Remove, missing: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			w := newFileRotator(rotateCfg{Path: path, Manifest: true})
+			if _, err := w.Write([]byte("entry\n")); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := ReadManifest(ManifestPath(path))
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("ReadManifest = %v, %v", entries, err)
+			}
+			if tc.mutate != nil {
+				if err := tc.mutate(filepath.Join(filepath.Dir(path), entries[0].Name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			bad, err := VerifyManifest(ManifestPath(path))
+			if tc.mutate == nil || tc.name == "gzipped" {
+				if err != nil || len(bad) != 0 {
+					t.Fatalf("VerifyManifest = %v, %v", bad, err)
+				}
+				return
+			}
+			var ce *ChecksumError
+			if !errors.As(err, &ce) || len(bad) != 1 {
+				t.Fatalf("VerifyManifest = %v, %v; want a ChecksumError", bad, err)
+			}
+			if missing := ce.Got == ""; missing != tc.missing {
+				t.Fatalf("ChecksumError %v, missing=%v", ce, tc.missing)
+			}
+		})
+	}
+}
+
+func TestManifestTimesAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	old := `{"level":"INFO","ts":"2020-01-02T03:04:05.000Z","msg":"first"}` + "\n" +
+		`{"level":"INFO","ts":"2020-01-02T03:09:05.000Z","msg":"second"}` + "\n"
+	if err := os.WriteFile(path, []byte(old), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newFileRotator(rotateCfg{Path: path, Manifest: true, TimeOf: entryTimeFunc(EncodingJSON, "ts")})
+	if _, err := w.Write([]byte(`{"level":"INFO","ts":"2020-01-02T03:10:00.000Z","msg":"third"}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadManifest(ManifestPath(path))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ReadManifest = %v, %v", entries, err)
+	}
+	want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if e := entries[0]; !e.First.Equal(want) || e.Entries != 3 {
+		t.Fatalf("first=%v entries=%d, want %v and 3", e.First, e.Entries, want)
+	}
+}
+
+func TestEntryTimeFunc(t *testing.T) {
+	want := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	for _, tc := range []struct {
+		encoding, line string
+		ok             bool
+	}{
+		{EncodingJSON, `{"ts":"2024-05-06T07:08:09.000Z","msg":"x"}`, true},
+		{EncodingJSON, `{"msg":"x","ts":"2024-05-06T07:08:09Z"}`, true},
+		{EncodingJSON, `{"ts":1714979289,"msg":"x"}`, true},
+		{EncodingJSON, `{"ts":1714979289000,"msg":"x"}`, true},
+		{EncodingJSON, `{"ts":1714979289000000000,"msg":"x"}`, true},
+		{EncodingJSON, `{"nested":{"ts":"2024-05-06T07:08:09Z"},"ts":"2024-05-06T07:08:09Z","msg":"trunc`, true},
+		{EncodingJSON, `{"msg":"x"}`, false},
+		{EncodingJSON, `{"ts":"yesterday"}`, false},
+		{EncodingJSON, `not json`, false},
+		{EncodingConsole, "2024-05-06T07:08:09.000Z\tINFO\tmsg", true},
+		{EncodingConsole, "INFO\tmsg", false},
+	} {
+		got, ok := entryTimeFunc(tc.encoding, "ts")([]byte(tc.line))
+		if ok != tc.ok || ok && !got.Equal(want) {
+			t.Errorf("%s %q = %v, %v", tc.encoding, tc.line, got, ok)
+		}
+	}
+	if entryTimeFunc(EncodingJSON, "") != nil {
+		t.Error("no time key should disable time parsing")
+	}
+}
+
+func TestManifestEntryCovers(t *testing.T) {
+	at := func(h int) time.Time { return time.Date(2024, 1, 1, h, 0, 0, 0, time.UTC) }
+	e := ManifestEntry{First: at(10), Last: at(12)}
+	for _, tc := range []struct {
+		from, to int
+		want     bool
+	}{
+		{8, 9, false},
+		{8, 10, true},
+		{11, 11, true},
+		{12, 14, true},
+		{13, 14, false},
+	} {
+		if got := e.Covers(at(tc.from), at(tc.to)); got != tc.want {
+			t.Errorf("Covers(%d, %d) = %v, want %v", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package zlog
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// MiddlewareOption configures Middleware
+	MiddlewareOption func(*middlewareCfg)
+
+	middlewareCfg struct {
+		header  string
+		capture *bodyCapture
+	}
+
+	// responseRecorder tracks what a handler writes
+	responseRecorder struct {
+		http.ResponseWriter
+		status int
+		bytes  int64
+		body   *captureBuffer // nil unless the response body is captured
+		onHead func(*responseRecorder)
+	}
+)
+
+const inboundMessage = "request"
+
+// MiddlewareRequestIDHeader sets the header the correlation ID is read from
+// and echoed in (DefaultRequestIDHeader by default)
+func MiddlewareRequestIDHeader(name string) MiddlewareOption {
+	return func(c *middlewareCfg) { c.header = name }
+}
+
+// Middleware writes an access entry for every request served by the wrapped
+// handler: method, path, status, response bytes, duration, remote_ip,
+// user_agent, x_forwarded_for and request_id.
+//
+// The correlation ID is taken from the request ID header, or generated, and
+// is available to handlers through RequestID(r.Context()). Responses with a
+// 5xx status, and handler panics, are also logged to Pair.Error. A handler
+// aborting with http.ErrAbortHandler is only logged to the access log, with
+// aborted=true.
+func Middleware(p *Pair, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	cfg := middlewareCfg{header: DefaultRequestIDHeader}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serve(p, &cfg, next, w, r)
+		})
+	}
+}
+
+func serve(p *Pair, cfg *middlewareCfg, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	id := r.Header.Get(cfg.header)
+	if id == "" {
+		id = randomID()
+	}
+	w.Header().Set(cfg.header, id)
+	r = r.WithContext(WithRequestID(r.Context(), id))
+
+	rw := &responseRecorder{ResponseWriter: w}
+	var reqBody *captureBuffer
+	if c := cfg.capture; c != nil && c.route(r.URL.Path) {
+		reqBody = c.captureRequest(r)
+		rw.onHead = c.captureResponse
+	}
+
+	panicked := true
+	defer func() {
+		if !panicked {
+			return
+		}
+		v := recover()
+		if rw.status == 0 && v != http.ErrAbortHandler {
+			rw.status = http.StatusInternalServerError
+		}
+		logRequest(p, cfg, r, rw, reqBody, id, time.Since(start), v)
+		panic(v)
+	}()
+	next.ServeHTTP(rw, r)
+	panicked = false
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	logRequest(p, cfg, r, rw, reqBody, id, time.Since(start), nil)
+}
+
+func logRequest(p *Pair, cfg *middlewareCfg, r *http.Request, rw *responseRecorder, reqBody *captureBuffer, id string, d time.Duration, panicValue any) {
+	// the handler gave up on the response on purpose, usually because the
+	// client went away
+	aborted := panicValue == http.ErrAbortHandler
+	if aborted {
+		panicValue = nil
+	}
+	failed := rw.status >= http.StatusInternalServerError || panicValue != nil
+	level := zapcore.InfoLevel
+	if failed {
+		level = zapcore.WarnLevel
+	}
+
+	fields := []zap.Field{zap.String("request_id", id)}
+	if aborted {
+		fields = append(fields, zap.Bool("aborted", true))
+	}
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		fields = append(fields, zap.String("x_forwarded_for", xff))
+	}
+	var bodies []zap.Field
+	if c := cfg.capture; c != nil {
+		bodies = c.fields(reqBody, rw.body)
+		if !c.OnlyOnFailure {
+			fields = append(fields, bodies...)
+			bodies = nil
+		} else if !c.failed(rw.status) {
+			bodies = nil
+		}
+	}
+
+	p.LogAccess(&AccessRecord{
+		Message:   inboundMessage,
+		Level:     level,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Status:    rw.status,
+		Bytes:     rw.bytes,
+		Duration:  d,
+		IP:        remoteIP(r.RemoteAddr),
+		UserAgent: r.UserAgent(),
+		Extra:     fields,
+	})
+	if !failed && bodies == nil {
+		return
+	}
+
+	msg := "request failed"
+	errFields := []zap.Field{
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.Int("status", rw.status),
+		zap.Duration("duration", d),
+		zap.String("request_id", id),
+	}
+	if panicValue != nil {
+		msg = "request handler panicked"
+		errFields = append(errFields, zap.Any("panic", panicValue))
+	}
+	p.Error.Error(msg, append(errFields, bodies...)...)
+}
+
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func randomID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	// informational responses (1xx) are followed by the real one
+	if w.status == 0 && status >= http.StatusOK {
+		w.status = status
+		if w.onHead != nil {
+			w.onHead(w)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		if w.Header().Get("Content-Type") == "" {
+			// what net/http would send, so capture can decide on it
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	if w.body != nil {
+		w.body.Write(b[:n])
+	}
+	return n, err
+}
+
+// ReadFrom lets the underlying writer use sendfile and the like when it
+// implements io.ReaderFrom and the response body is not captured
+func (w *responseRecorder) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := w.ResponseWriter.(io.ReaderFrom)
+	if !ok || w.onHead != nil {
+		// through Write, hiding ReadFrom from io.Copy
+		return io.Copy(struct{ io.Writer }{w}, r)
+	}
+	if w.status == 0 {
+		// net/http still sniffs the content type from the first bytes
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := rf.ReadFrom(r)
+	w.bytes += n
+	return n, err
+}
+
+// Hijack implements http.Hijacker when the underlying writer does. The
+// request is logged with status 101 unless the handler wrote one.
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, brw, err := h.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Flush implements http.Flusher when the underlying writer does
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,289 @@
+package zlog
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	p, rec := Test(t)
+	h := Middleware(p)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RequestID(r.Context()) != "abc" {
+			t.Errorf("handler sees request ID %q", RequestID(r.Context()))
+		}
+		io.WriteString(w, "hello")
+	}))
+	req := httptest.NewRequest("GET", "/users?id=1", nil)
+	req.Header.Set(DefaultRequestIDHeader, "abc")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Header().Get(DefaultRequestIDHeader) != "abc" {
+		t.Error("request ID not echoed")
+	}
+	e := rec.Access()[0]
+	for k, v := range map[string]any{"status": 200.0, "bytes": 5.0, "path": "/users", "request_id": "abc", "x_forwarded_for": "203.0.113.7", "remote_ip": "192.0.2.1"} {
+		if e.Fields[k] != v {
+			t.Errorf("%s = %v, want %v", k, e.Fields[k], v)
+		}
+	}
+	if len(rec.Error()) != 0 {
+		t.Error("successful request logged as an error")
+	}
+}
+
+func TestMiddlewareFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		status  float64
+		errMsg  string
+		aborted bool
+	}{
+		{"server error", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) }, 502, "request failed", false},
+		{"panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, 500, "request handler panicked", false},
+		{"abort", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "partial")
+			panic(http.ErrAbortHandler)
+		}, 200, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, rec := Test(t)
+			h := Middleware(p)(tc.handler)
+			func() {
+				defer func() {
+					if v := recover(); (v != nil) != (tc.name != "server error") {
+						t.Errorf("recovered %v", v)
+					}
+				}()
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			}()
+			a := rec.Access()[0]
+			if a.Fields["status"] != tc.status || (a.Fields["aborted"] == true) != tc.aborted {
+				t.Errorf("access entry %v", a.Fields)
+			}
+			errs := rec.Error()
+			if tc.errMsg == "" && len(errs) != 0 {
+				t.Errorf("error entries %v", errs)
+			}
+			if tc.errMsg != "" && (len(errs) != 1 || errs[0].Message != tc.errMsg) {
+				t.Errorf("error entries %v, want %q", errs, tc.errMsg)
+			}
+		})
+	}
+}
+
+func TestMiddlewareHijack(t *testing.T) {
+	p, rec := Test(t)
+	srv := httptest.NewServer(Middleware(p)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		brw.Flush()
+	})))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	// the handler may still be returning
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.Access()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if a := rec.Access(); len(a) != 1 || a[0].Fields["status"] != 101.0 {
+		t.Fatalf("access entries %v", a)
+	}
+}
+
+func TestResponseRecorderHijackUnsupported(t *testing.T) {
+	rw := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+	if _, _, err := rw.Hijack(); err != http.ErrNotSupported {
+		t.Fatalf("Hijack = %v", err)
+	}
+}
+
+// readFromWriter records whether ReadFrom was used
+type readFromWriter struct {
+	*httptest.ResponseRecorder
+	used bool
+}
+
+func (w *readFromWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.used = true
+	return io.Copy(w.ResponseRecorder, r)
+}
+
+func TestResponseRecorderReadFrom(t *testing.T) {
+	for _, capture := range []bool{false, true} {
+		under := &readFromWriter{ResponseRecorder: httptest.NewRecorder()}
+		rw := &responseRecorder{ResponseWriter: under}
+		if capture {
+			rw.onHead = func(*responseRecorder) {}
+		}
+		// hide strings.Reader's WriteTo, which io.Copy would prefer
+		n, err := io.Copy(rw, struct{ io.Reader }{strings.NewReader("<html>hello</html>")})
+		if err != nil || n != 18 || rw.bytes != 18 || rw.status != 200 {
+			t.Fatalf("capture=%v: n=%d err=%v bytes=%d status=%d", capture, n, err, rw.bytes, rw.status)
+		}
+		if under.used == capture {
+			t.Errorf("capture=%v: underlying ReadFrom used=%v", capture, under.used)
+		}
+	}
+}
+
+// serveCapture runs one request through Middleware with CaptureBodies(c)
+// and returns the access entry and the error entries
+func serveCapture(t *testing.T, c BodyCapture, h http.HandlerFunc, req *http.Request) (RecordedEntry, []RecordedEntry) {
+	t.Helper()
+	p, rec := Test(t)
+	Middleware(p, CaptureBodies(c))(h).ServeHTTP(httptest.NewRecorder(), req)
+	a := rec.Access()
+	if len(a) != 1 {
+		t.Fatalf("access entries %v", a)
+	}
+	return a[0], rec.Error()
+}
+
+func bodyRequest(path, contentType, body string) *http.Request {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+// reply reads n bytes of the request body (all if n < 0) and answers with
+// body as contentType
+func reply(n int, status int, contentType, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if n < 0 {
+			io.ReadAll(r.Body)
+		} else {
+			io.ReadFull(r.Body, make([]byte, n))
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+func TestCaptureBodiesLimits(t *testing.T) {
+	c := BodyCapture{MaxBytes: 8, ContentTypes: []string{"text/*"}}
+	a, _ := serveCapture(t, c, reply(-1, 200, "text/plain", "hello, world"), bodyRequest("/", "text/plain", "0123456789"))
+	for k, v := range map[string]any{
+		"request_body": "01234567", "request_body_truncated": true,
+		"response_body": "hello, w", "response_body_truncated": true,
+	} {
+		if a.Fields[k] != v {
+			t.Errorf("%s = %v, want %v", k, a.Fields[k], v)
+		}
+	}
+
+	// only what the handler reads is captured
+	a, _ = serveCapture(t, c, reply(4, 200, "text/plain", "ok"), bodyRequest("/", "text/plain; charset=utf-8", "0123456789"))
+	if a.Fields["request_body"] != "0123" || a.Fields["request_body_truncated"] != nil || a.Fields["response_body"] != "ok" {
+		t.Errorf("fields %v", a.Fields)
+	}
+}
+
+func TestCaptureBodiesSelection(t *testing.T) {
+	c := BodyCapture{Routes: []string{"/api/"}}
+	for _, tc := range []struct {
+		name, path, reqType, respType string
+		req, resp                     bool
+	}{
+		{"json on route", "/api/users", "application/json", "application/json; charset=utf-8", true, true},
+		{"other route", "/static/x", "application/json", "application/json", false, false},
+		{"text request", "/api/users", "text/plain", "application/json", false, true},
+		{"html response", "/api/users", "application/json", "text/html", true, false},
+		{"no content type", "/api/users", "", "", false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, _ := serveCapture(t, c, reply(-1, 200, tc.respType, `{"id":1}`), bodyRequest(tc.path, tc.reqType, `{"name":"a"}`))
+			if _, ok := a.Fields["request_body"]; ok != tc.req {
+				t.Errorf("request body captured: %v, want %v", ok, tc.req)
+			}
+			if _, ok := a.Fields["response_body"]; ok != tc.resp {
+				t.Errorf("response body captured: %v, want %v", ok, tc.resp)
+			}
+		})
+	}
+}
+
+func TestCaptureBodiesRedact(t *testing.T) {
+	c := BodyCapture{RedactFields: []string{"Password", "token"}}
+	req := `{"user":"a","PASSWORD":"hunter2","sessions":[{"token":"t1","id":7}]}`
+	a, _ := serveCapture(t, c, reply(-1, 200, "application/json", `{"token":"t2","ok":true}`), bodyRequest("/", "application/json", req))
+	var got struct {
+		User     string
+		Password string `json:"PASSWORD"`
+		Sessions []struct {
+			Token string
+			ID    int
+		}
+	}
+	if err := json.Unmarshal([]byte(a.Fields["request_body"].(string)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.User != "a" || got.Password != redactedValue || len(got.Sessions) != 1 || got.Sessions[0].Token != redactedValue || got.Sessions[0].ID != 7 {
+		t.Errorf("request body %+v", got)
+	}
+	if body := a.Fields["response_body"]; body != `{"ok":true,"token":"`+redactedValue+`"}` {
+		t.Errorf("response body %v", body)
+	}
+
+	// a truncated JSON body cannot be redacted
+	c.MaxBytes = 10
+	a, _ = serveCapture(t, c, reply(-1, 200, "text/plain", ""), bodyRequest("/", "application/json", req))
+	if a.Fields["request_body"] != redactedValue || a.Fields["request_body_truncated"] != nil {
+		t.Errorf("truncated body fields %v", a.Fields)
+	}
+}
+
+func TestCaptureBodiesOnlyOnFailure(t *testing.T) {
+	c := BodyCapture{OnlyOnFailure: true, FailureStatus: 400}
+	for _, tc := range []struct {
+		status int
+		errMsg string
+	}{
+		{200, ""},
+		{404, "request failed"},
+		{503, "request failed"},
+	} {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			a, errs := serveCapture(t, c, reply(-1, tc.status, "application/json", `{"error":"x"}`), bodyRequest("/", "application/json", `{"q":1}`))
+			if _, ok := a.Fields["request_body"]; ok {
+				t.Errorf("bodies on the access entry: %v", a.Fields)
+			}
+			if tc.errMsg == "" {
+				if len(errs) != 0 {
+					t.Errorf("error entries %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Message != tc.errMsg {
+				t.Fatalf("error entries %v, want %q", errs, tc.errMsg)
+			}
+			if f := errs[0].Fields; f["request_body"] != `{"q":1}` || f["response_body"] != `{"error":"x"}` || f["status"] != float64(tc.status) {
+				t.Errorf("error entry fields %v", f)
+			}
+		})
+	}
+}
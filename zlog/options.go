@@ -1,58 +1,77 @@
 package zlog
 
 import (
+	"fmt"
+	"io"
+	"time"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 type Option func(*buildCfg)
 
-// WithAccessFile configures access log file rotation
-func WithAccessFile(path string, maxSizeMB, maxBackups, maxAgeDays int, compress bool) Option {
+// FileOption tunes a single file sink configured with WithAccessFile or WithErrorFile
+type FileOption func(*rotateCfg)
+
+// WithAccessFile configures access log file rotation.
+// The path may be a template such as /var/log/app/{tenant}/access.log whose
+// placeholders are filled from entry (or logger context) fields; each
+// resolved path gets its own rotating file with these settings.
+func WithAccessFile(path string, maxSizeMB, maxBackups, maxAgeDays int, compress bool, opts ...FileOption) Option {
 	return func(c *buildCfg) {
-		// Validate and normalize parameters
-		if maxSizeMB < 0 {
-			maxSizeMB = 0
-		}
-		if maxBackups < 0 {
-			maxBackups = 0
-		}
-		if maxAgeDays < 0 {
-			maxAgeDays = 0
-		}
-		c.access = rotateCfg{
-			Path:       path,
-			MaxSizeMB:  maxSizeMB,
-			MaxBackups: maxBackups,
-			MaxAgeDays: maxAgeDays,
-			Compress:   compress,
-		}
+		c.access = fileCfg(path, maxSizeMB, maxBackups, maxAgeDays, compress, opts)
 	}
 }
 
-// WithErrorFile configures error log file rotation
-func WithErrorFile(path string, maxSizeMB, maxBackups, maxAgeDays int, compress bool) Option {
+// WithErrorFile configures error log file rotation.
+// The file gets every entry of the error logger, alongside any routes added
+// with WithErrorRoute.
+func WithErrorFile(path string, maxSizeMB, maxBackups, maxAgeDays int, compress bool, opts ...FileOption) Option {
 	return func(c *buildCfg) {
-		// Validate and normalize parameters
-		if maxSizeMB < 0 {
-			maxSizeMB = 0
-		}
-		if maxBackups < 0 {
-			maxBackups = 0
-		}
-		if maxAgeDays < 0 {
-			maxAgeDays = 0
-		}
-		c.error = rotateCfg{
-			Path:       path,
-			MaxSizeMB:  maxSizeMB,
-			MaxBackups: maxBackups,
-			MaxAgeDays: maxAgeDays,
-			Compress:   compress,
-		}
+		c.error = fileCfg(path, maxSizeMB, maxBackups, maxAgeDays, compress, opts)
+	}
+}
+
+// WithErrorRoute sends error logger entries with minLevel <= level <= maxLevel
+// to their own rotating file, e.g. warn.log, error.log and fatal.log.
+// Routes accumulate and may overlap; a WithErrorFile target, if any, still
+// gets every entry.
+func WithErrorRoute(minLevel, maxLevel zapcore.Level, path string, maxSizeMB, maxBackups, maxAgeDays int, compress bool, opts ...FileOption) Option {
+	return func(c *buildCfg) {
+		c.errorRoutes = append(c.errorRoutes, levelRoute{
+			min:  minLevel,
+			max:  maxLevel,
+			file: fileCfg(path, maxSizeMB, maxBackups, maxAgeDays, compress, opts),
+		})
 	}
 }
 
+func fileCfg(path string, maxSizeMB, maxBackups, maxAgeDays int, compress bool, opts []FileOption) rotateCfg {
+	// Validate and normalize parameters
+	if maxSizeMB < 0 {
+		maxSizeMB = 0
+	}
+	if maxBackups < 0 {
+		maxBackups = 0
+	}
+	if maxAgeDays < 0 {
+		maxAgeDays = 0
+	}
+	c := rotateCfg{
+		Path:       path,
+		MaxSizeMB:  maxSizeMB,
+		MaxBackups: maxBackups,
+		MaxAgeDays: maxAgeDays,
+		Compress:   compress,
+		Level:      zapcore.DebugLevel,
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+	return c
+}
+
 // WithConsoleForAccess enables/disables console stdout output for access logs
 func WithConsoleForAccess(enable bool) Option {
 	return func(c *buildCfg) { c.consoleStdout = enable }
@@ -63,6 +82,24 @@ func WithConsoleForError(enable bool) Option {
 	return func(c *buildCfg) { c.consoleStderr = enable }
 }
 
+// WithAccessConsole sends access entries with minLevel <= level <= maxLevel to w.
+// Calls accumulate, so levels can be split across writers, e.g. info to
+// os.Stdout and warn and above to os.Stderr. It is independent of
+// WithConsoleForAccess.
+func WithAccessConsole(w io.Writer, minLevel, maxLevel zapcore.Level) Option {
+	return func(c *buildCfg) {
+		c.accessConsoles = append(c.accessConsoles, consoleRoute{w: w, min: minLevel, max: maxLevel})
+	}
+}
+
+// WithErrorConsole sends error entries with minLevel <= level <= maxLevel to w.
+// Calls accumulate; it is independent of WithConsoleForError.
+func WithErrorConsole(w io.Writer, minLevel, maxLevel zapcore.Level) Option {
+	return func(c *buildCfg) {
+		c.errorConsoles = append(c.errorConsoles, consoleRoute{w: w, min: minLevel, max: maxLevel})
+	}
+}
+
 // WithInitialLevels sets initial logging levels for access and error loggers
 func WithInitialLevels(access, err zapcore.Level) Option {
 	return func(c *buildCfg) {
@@ -76,9 +113,250 @@ func WithEncoder(enc zapcore.EncoderConfig) Option {
 	return func(c *buildCfg) { c.enc = enc }
 }
 
+// Encodings accepted by WithEncoding
+const (
+	EncodingJSON    = "json"
+	EncodingConsole = "console"
+)
+
+// WithEncoding sets the encoding ("json" or "console") of file sinks and of
+// console sinks (stdout, stderr, mirrors). Both default to "json"; New fails
+// on any other value.
+func WithEncoding(file, console string) Option {
+	return func(c *buildCfg) {
+		for _, enc := range []string{file, console} {
+			if err := checkEncoding(enc); err != nil {
+				c.errs = append(c.errs, err)
+				return
+			}
+		}
+		c.fileEncoding = file
+		c.consoleEncoding = console
+	}
+}
+
+func checkEncoding(enc string) error {
+	if enc != EncodingJSON && enc != EncodingConsole {
+		return fmt.Errorf("zlog: unknown encoding %q, want %q or %q", enc, EncodingJSON, EncodingConsole)
+	}
+	return nil
+}
+
+// WithColor colors level names on console sinks using the console encoding
+func WithColor(enable bool) Option {
+	return func(c *buildCfg) { c.color = enable }
+}
+
+// WithAccessSampling samples the access logger: per tick, the first entries
+// with a given level and message are logged, then every thereafter-th one.
+// Entries dropped by filter rules do not count. The error logger is never
+// sampled.
+func WithAccessSampling(tick time.Duration, first, thereafter int) Option {
+	return func(c *buildCfg) {
+		if tick <= 0 {
+			c.sampling = nil
+			return
+		}
+		c.sampling = &samplingCfg{tick: tick, first: first, thereafter: thereafter}
+	}
+}
+
+// WithFields adds fields to every entry of both loggers
+func WithFields(fields ...zap.Field) Option {
+	return func(c *buildCfg) {
+		c.zapOpts = append(c.zapOpts, zap.Fields(fields...))
+	}
+}
+
+// WithSensitiveHash makes sinks that are not trusted render Sensitive fields
+// as "hmac:<hex>", a truncated HMAC-SHA256 keyed by secret, instead of
+// "[REDACTED]", so equal values can be correlated
+func WithSensitiveHash(secret []byte) Option {
+	return func(c *buildCfg) {
+		if len(secret) == 0 {
+			c.sensitiveHash = nil
+			return
+		}
+		c.sensitiveHash = hashSensitive(append([]byte(nil), secret...))
+	}
+}
+
+// WithIPAnonymization truncates or pseudonymizes client addresses in the
+// entries of both loggers before any sink sees them; see IPAnonymizer
+func WithIPAnonymization(a IPAnonymizer) Option {
+	return func(c *buildCfg) { c.ipAnonymizer = &a }
+}
+
+// WithRecorder captures the entries of both loggers in r, in addition to the
+// other sinks. The recorder sinks are named "access:recorder" and "error:recorder".
+func WithRecorder(r *Recorder) Option {
+	return func(c *buildCfg) { c.recorder = r }
+}
+
+// WithAccessProcessors runs ps, in order, on every entry of the access
+// logger before it reaches the sinks
+func WithAccessProcessors(ps ...Processor) Option {
+	return func(c *buildCfg) { c.accessProcs = append(c.accessProcs, ps...) }
+}
+
+// WithErrorProcessors runs ps, in order, on every entry of the error logger
+// before it reaches the sinks
+func WithErrorProcessors(ps ...Processor) Option {
+	return func(c *buildCfg) { c.errorProcs = append(c.errorProcs, ps...) }
+}
+
+// WithFilters sets the initial filter rules of both loggers; see FilterRule.
+// New fails if an expression does not compile. Change the rules later with
+// Pair.Filters.Set.
+func WithFilters(rules ...FilterRule) Option {
+	return func(c *buildCfg) { c.filters = append(c.filters, rules...) }
+}
+
+// WithSequence stamps every entry of both loggers with "seq", a sequence
+// number shared by all loggers of the process, so entries of the access and
+// error logs can be merged in exact order. With ulid, entries also get a
+// unique, monotonic ULID as "entry_id" for deduplication downstream; unlike
+// the sequence number, the ULID string costs an allocation per entry.
+func WithSequence(ulid bool) Option {
+	return func(c *buildCfg) { c.stamper = &entryStamper{ids: &processIDs, ulid: ulid} }
+}
+
+// WithSequenceKeys renames the "seq" and "entry_id" keys of WithSequence,
+// for pipelines that already use those names. New fails if a key is empty
+// or both are the same.
+func WithSequenceKeys(seq, id string) Option {
+	return func(c *buildCfg) {
+		if seq == "" || id == "" || seq == id {
+			c.errs = append(c.errs, fmt.Errorf("zlog: invalid sequence keys %q and %q", seq, id))
+			return
+		}
+		c.seqKey, c.entryIDKey = seq, id
+	}
+}
+
+// WithCrashOutput sends reports of unrecovered panics and fatal runtime
+// errors to CrashPath(errorPath), next to the error log, instead of only
+// stderr. A report left by a previous run is logged to Pair.Error at startup,
+// with the reason and parsed goroutine stacks. The crash output is process
+// wide, so only one Pair should use this option; Close restores stderr.
+func WithCrashOutput() Option {
+	return func(c *buildCfg) { c.crashOutput = true }
+}
+
 // WithZapOptions sets native zap.Option for loggers
 func WithZapOptions(opts ...zap.Option) Option {
 	return func(c *buildCfg) {
 		c.zapOpts = append(c.zapOpts, opts...)
 	}
 }
+
+// FsyncNever leaves flushing to the OS page cache; entries reach disk only on an explicit Sync
+func FsyncNever() FileOption {
+	return func(c *rotateCfg) { c.Fsync = syncPolicy{mode: syncNever} }
+}
+
+// FsyncAlways makes every write durable before it returns.
+// Concurrent writers share a single fsync (group commit).
+func FsyncAlways() FileOption {
+	return func(c *rotateCfg) { c.Fsync = syncPolicy{mode: syncAlways} }
+}
+
+// FsyncEvery forces an fsync after every n entries; n <= 1 behaves like FsyncAlways
+func FsyncEvery(n int) FileOption {
+	return func(c *rotateCfg) {
+		if n <= 1 {
+			c.Fsync = syncPolicy{mode: syncAlways}
+			return
+		}
+		c.Fsync = syncPolicy{mode: syncEveryN, every: n}
+	}
+}
+
+// FsyncInterval forces an fsync of outstanding entries every d from a background goroutine
+func FsyncInterval(d time.Duration) FileOption {
+	return func(c *rotateCfg) {
+		if d <= 0 {
+			c.Fsync = syncPolicy{mode: syncNever}
+			return
+		}
+		c.Fsync = syncPolicy{mode: syncInterval, interval: d}
+	}
+}
+
+// ChecksumManifest makes the sink record every rotated backup (name, size,
+// first/last write time, entry count and SHA-256) in path + ".manifest"
+func ChecksumManifest() FileOption {
+	return func(c *rotateCfg) { c.Manifest = true }
+}
+
+// Sharded trades durability for throughput: writers encode into one of
+// shards buffers picked at random (GOMAXPROCS buffers when shards <= 0) and a
+// single goroutine writes them to the file every flushEvery (100ms when <= 0)
+// or when a shard fills. A writer finding its shard at the size cap flushes
+// synchronously. Entries of one goroutine keep their order; entries of
+// different goroutines are ordered by an internal sequence number within
+// each batch. Sync and Close flush the buffers first and report failed
+// background writes; fsync policies apply when batches are written.
+func Sharded(shards int, flushEvery time.Duration) FileOption {
+	return func(c *rotateCfg) {
+		c.Sharded = true
+		c.Shards = shards
+		c.ShardFlush = flushEvery
+	}
+}
+
+// Trusted marks the sink as allowed to store the real value of Sensitive
+// fields (e.g. an encrypted audit volume). Mirrors of the sink are not trusted.
+func Trusted() FileOption {
+	return func(c *rotateCfg) { c.Trusted = true }
+}
+
+// LocalTimeBackups stamps backup names with local time instead of UTC
+func LocalTimeBackups() FileOption {
+	return func(c *rotateCfg) { c.LocalTime = true }
+}
+
+// Retention compacts backups as they age, in addition to MaxBackups and
+// MaxAgeDays: once a backup is older than a tier's After it is rewritten
+// (and recompressed) with only the entries that tier keeps. Each backup is
+// rewritten once per tier it enters; progress is kept in path + ".retention".
+// Backups are checked when the sink is created, after each rotation, and
+// every tenth of the youngest tier's After (between a minute and an hour).
+func Retention(tiers ...RetentionTier) FileOption {
+	return func(c *rotateCfg) { c.Retention = sortTiers(tiers) }
+}
+
+// Processors runs ps, in order, on the entries of this sink only, after the
+// processors of the logger. They run once per entry for the sink and its
+// mirror, which both write the result.
+func Processors(ps ...Processor) FileOption {
+	return func(c *rotateCfg) { c.Processors = append(c.Processors, ps...) }
+}
+
+// SinkName names the sink in Pair.SinkLevels instead of "<logger>:<path>"
+func SinkName(name string) FileOption {
+	return func(c *rotateCfg) { c.Name = name }
+}
+
+// SinkLevel sets the initial level of the sink. The sink writes an entry only
+// if the logger level allows it too, so the logger level is the ceiling.
+func SinkLevel(l zapcore.Level) FileOption {
+	return func(c *rotateCfg) { c.Level = l }
+}
+
+// Mirror copies the file's entries to w (e.g. os.Stderr) at the same levels
+func Mirror(w io.Writer) FileOption {
+	return func(c *rotateCfg) { c.Console = w }
+}
+
+// TemplateMaxOpen bounds how many files a path template keeps open; the least
+// recently used one is closed when the limit is hit. Defaults to 64.
+func TemplateMaxOpen(n int) FileOption {
+	return func(c *rotateCfg) { c.MaxOpen = n }
+}
+
+// TemplateFallback sets the file used when a path template placeholder has no
+// matching field. Without it the placeholder is replaced by "unknown".
+func TemplateFallback(path string) FileOption {
+	return func(c *rotateCfg) { c.Fallback = path }
+}
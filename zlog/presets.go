@@ -0,0 +1,109 @@
+package zlog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// TB is the part of testing.TB used by Test
+	TB interface {
+		Helper()
+		Logf(format string, args ...any)
+		Fatalf(format string, args ...any)
+		Cleanup(func())
+	}
+
+	// tbWriter forwards console output to the test log until the test ends;
+	// testing panics on Logf after that, e.g. from a leaked goroutine
+	tbWriter struct {
+		t    TB
+		mu   sync.Mutex
+		done bool
+	}
+)
+
+// Development returns a pair for local work: debug level on both loggers,
+// colored console output (access to stdout, errors to stderr), no files and
+// zap's development mode. opts are applied on top.
+func Development(opts ...Option) (*Pair, error) {
+	return New(append([]Option{
+		WithConsoleForAccess(true),
+		WithConsoleForError(true),
+		WithEncoding(EncodingJSON, EncodingConsole),
+		WithColor(true),
+		WithInitialLevels(zapcore.DebugLevel, zapcore.DebugLevel),
+		WithZapOptions(zap.Development()),
+	}, opts...)...)
+}
+
+// Production returns a pair writing JSON to dir/access.log and dir/error.log
+// with rotation (100MB, 10 backups, 30 days, compressed), info/warn levels,
+// sampling of repeated access entries and host/pid/service fields on every
+// entry. opts are applied on top.
+func Production(dir string, opts ...Option) (*Pair, error) {
+	return New(append([]Option{
+		WithAccessFile(filepath.Join(dir, "access.log"), 100, 10, 30, true),
+		WithErrorFile(filepath.Join(dir, "error.log"), 100, 10, 30, true),
+		WithInitialLevels(zapcore.InfoLevel, zapcore.WarnLevel),
+		WithAccessSampling(time.Second, 100, 100),
+		WithFields(resourceFields()...),
+	}, opts...)...)
+}
+
+// Test returns a pair for use in tests: debug level on both loggers, entries
+// captured by the returned Recorder and echoed to t.Logf. The pair is closed
+// when the test ends. opts are applied on top; building errors fail the test.
+func Test(t TB, opts ...Option) (*Pair, *Recorder) {
+	t.Helper()
+	rec := NewRecorder()
+	tw := &tbWriter{t: t}
+	// cleanups run last-in first-out: this one runs after the pair is closed
+	t.Cleanup(tw.stop)
+	p, err := New(append([]Option{
+		WithRecorder(rec),
+		WithAccessConsole(tw, zapcore.DebugLevel, zapcore.FatalLevel),
+		WithErrorConsole(tw, zapcore.DebugLevel, zapcore.FatalLevel),
+		WithEncoding(EncodingJSON, EncodingConsole),
+		WithInitialLevels(zapcore.DebugLevel, zapcore.DebugLevel),
+	}, opts...)...)
+	if err != nil {
+		t.Fatalf("zlog: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p, rec
+}
+
+func (w *tbWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.done {
+		w.t.Helper()
+		w.t.Logf("%s", strings.TrimSuffix(string(p), "\n"))
+	}
+	return len(p), nil
+}
+
+func (w *tbWriter) stop() {
+	w.mu.Lock()
+	w.done = true
+	w.mu.Unlock()
+}
+
+// resourceFields describes the running process
+func resourceFields() []zap.Field {
+	fields := []zap.Field{
+		zap.Int("pid", os.Getpid()),
+		zap.String("service", filepath.Base(os.Args[0])),
+	}
+	if host, err := os.Hostname(); err == nil {
+		fields = append(fields, zap.String("host", host))
+	}
+	return fields
+}
@@ -0,0 +1,117 @@
+package zlog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// redirectStd points os.Stdout and os.Stderr at files for the test and
+// returns their paths
+func redirectStd(t *testing.T) (stdout, stderr string) {
+	dir := t.TempDir()
+	stdout, stderr = filepath.Join(dir, "stdout"), filepath.Join(dir, "stderr")
+	out, err := os.Create(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errf, err := os.Create(stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevOut, prevErr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = out, errf
+	t.Cleanup(func() {
+		os.Stdout, os.Stderr = prevOut, prevErr
+		out.Close()
+		errf.Close()
+	})
+	return stdout, stderr
+}
+
+func TestDevelopmentPreset(t *testing.T) {
+	stdout, stderr := redirectStd(t)
+	p, err := Development()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.AccessLevel.Level() != zapcore.DebugLevel || p.ErrorLevel.Level() != zapcore.DebugLevel {
+		t.Errorf("levels = %v, %v, want debug", p.AccessLevel.Level(), p.ErrorLevel.Level())
+	}
+	if names := p.SinkNames(); !slices.Equal(names, []string{"access:stdout", "error:stderr"}) {
+		t.Errorf("sinks = %v", names)
+	}
+	p.Access.Debug("request")
+	p.Error.Debug("detail")
+	_ = p.Sync()
+
+	for path, msg := range map[string]string{stdout: "request", stderr: "detail"} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := string(b)
+		// colored console encoding rather than JSON
+		if !strings.Contains(out, msg) || !strings.Contains(out, "\x1b[") || strings.HasPrefix(out, "{") {
+			t.Errorf("%s: %q", filepath.Base(path), out)
+		}
+	}
+	// development mode: DPanic panics
+	defer func() {
+		if recover() == nil {
+			t.Error("DPanic did not panic")
+		}
+	}()
+	p.Error.DPanic("bug")
+}
+
+func TestProductionPreset(t *testing.T) {
+	dir := t.TempDir()
+	p, err := Production(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.AccessLevel.Level() != zapcore.InfoLevel || p.ErrorLevel.Level() != zapcore.WarnLevel {
+		t.Errorf("levels = %v, %v, want info, warn", p.AccessLevel.Level(), p.ErrorLevel.Level())
+	}
+	access, errorPath := filepath.Join(dir, "access.log"), filepath.Join(dir, "error.log")
+	if names := p.SinkNames(); !slices.Equal(names, []string{"access:" + access, "error:" + errorPath}) {
+		t.Errorf("sinks = %v", names)
+	}
+	for range 150 {
+		p.Access.Info("hot")
+	}
+	p.Access.Debug("hidden")
+	p.Error.Info("hidden")
+	p.Error.Warn("slow")
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, access)
+	// 100 per second, then every 100th
+	if len(lines) < 100 || len(lines) >= 150 {
+		t.Errorf("%d access entries, want the sampled ones", len(lines))
+	}
+	errs := readLines(t, errorPath)
+	if len(errs) != 1 {
+		t.Fatalf("error entries %q", errs)
+	}
+	for _, l := range []string{lines[0], errs[0]} {
+		var e map[string]any
+		if err := json.Unmarshal([]byte(l), &e); err != nil {
+			t.Fatalf("not JSON: %q", l)
+		}
+		for _, k := range []string{"pid", "service", "host"} {
+			if _, ok := e[k]; !ok {
+				t.Errorf("entry without %s: %q", k, l)
+			}
+		}
+	}
+}
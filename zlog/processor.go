@@ -0,0 +1,122 @@
+package zlog
+
+import (
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// Processor inspects and rewrites an entry before it is encoded. It returns
+	// false to drop the entry. Processors see the fields added with Logger.With
+	// first, then the fields passed with the entry.
+	Processor func(r *Record) bool
+
+	// Record is the entry handed to processors. Fields belongs to the chain and
+	// may be changed in place or with the helper methods.
+	Record struct {
+		Entry  zapcore.Entry
+		Fields []zapcore.Field
+	}
+
+	processorChain []Processor
+)
+
+// Field returns the first field named key
+func (r *Record) Field(key string) (zapcore.Field, bool) {
+	for _, f := range r.Fields {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return zapcore.Field{}, false
+}
+
+// Add appends fields
+func (r *Record) Add(fields ...zapcore.Field) {
+	r.Fields = append(r.Fields, fields...)
+}
+
+// Set replaces the fields named like f with f, or appends it
+func (r *Record) Set(f zapcore.Field) {
+	found := false
+	out := r.Fields[:0]
+	for _, o := range r.Fields {
+		if o.Key != f.Key {
+			out = append(out, o)
+		} else if !found {
+			out = append(out, f)
+			found = true
+		}
+	}
+	if !found {
+		out = append(out, f)
+	}
+	r.Fields = out
+}
+
+// Rename renames the fields named from
+func (r *Record) Rename(from, to string) {
+	for i := range r.Fields {
+		if r.Fields[i].Key == from {
+			r.Fields[i].Key = to
+		}
+	}
+}
+
+// Drop removes the fields with the given keys
+func (r *Record) Drop(keys ...string) {
+	out := r.Fields[:0]
+	for _, f := range r.Fields {
+		drop := false
+		for _, k := range keys {
+			if f.Key == k {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			out = append(out, f)
+		}
+	}
+	clear(r.Fields[len(out):])
+	r.Fields = out
+}
+
+// run passes an entry through the chain in order. It returns keep == false
+// if a processor dropped the entry. A processor that panics is skipped, with
+// its changes rolled back, and reported in err. The record's fields live in
+// buf, so the returned fields are valid until buf is freed.
+func (pc processorChain) run(buf *entryBuf, ent zapcore.Entry, fields []zapcore.Field) (_ zapcore.Entry, _ []zapcore.Field, keep bool, err error) {
+	// the record lives in buf too: processors get a pointer to it
+	r := &buf.rec
+	*r = Record{Entry: ent, Fields: buf.own(fields)}
+	var errs []error
+	for i, p := range pc {
+		saved := r.Entry
+		buf.saved = append(buf.saved[:0], r.Fields...)
+		ok, perr := callProcessor(p, r)
+		if perr != nil {
+			errs = append(errs, fmt.Errorf("zlog: processor %d: %w", i, perr))
+			r.Entry = saved
+			r.Fields = append(r.Fields[:0], buf.saved...)
+			continue
+		}
+		if !ok {
+			buf.fields = r.Fields
+			return r.Entry, nil, false, errors.Join(errs...)
+		}
+	}
+	buf.fields = r.Fields
+	return r.Entry, r.Fields, true, errors.Join(errs...)
+}
+
+func callProcessor(p Processor, r *Record) (keep bool, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return p(r), nil
+}
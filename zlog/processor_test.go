@@ -0,0 +1,106 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestProcessorsSeeWithFields(t *testing.T) {
+	var seen []string
+	p, rec := Test(t,
+		WithFields(zap.String("service", "api")),
+		WithAccessProcessors(func(r *Record) bool {
+			seen = seen[:0]
+			for _, f := range r.Fields {
+				seen = append(seen, f.Key)
+			}
+			r.Rename("tenant", "org")
+			r.Drop("secret")
+			return true
+		}))
+	p.Access.With(zap.String("tenant", "acme"), zap.String("secret", "x")).Info("hello", zap.Int("n", 1))
+
+	if got := strings.Join(seen, ","); got != "service,tenant,secret,n" {
+		t.Fatalf("processor saw %s", got)
+	}
+	f := rec.Access()[0].Fields
+	if f["org"] != "acme" || f["service"] != "api" || f["n"] != 1.0 {
+		t.Fatalf("fields %v", f)
+	}
+	if _, ok := f["tenant"]; ok {
+		t.Fatalf("renamed With field written: %v", f)
+	}
+	if _, ok := f["secret"]; ok {
+		t.Fatalf("dropped With field written: %v", f)
+	}
+}
+
+// Holding the With fields for the processors does not allocate per entry
+func TestProcessorsWithFieldsAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable under the race detector")
+	}
+	p := benchPair(t, io.Discard, WithFields(zap.String("service", "api")),
+		WithAccessProcessors(func(r *Record) bool { return true }))
+	rec := testRecord()
+	if n := testing.AllocsPerRun(100, func() { p.LogAccess(rec) }); n != 0 {
+		t.Errorf("LogAccess allocates %v times per call", n)
+	}
+}
+
+// With fields are anonymized once, with the entry, when processors hold them
+// back
+func TestProcessorsWithFieldsAnonymizedOnce(t *testing.T) {
+	cfg := IPAnonymizer{Pseudonymize: true, Secret: []byte("s"), Fields: []string{"client"}}
+	p, rec := Test(t, WithIPAnonymization(cfg), WithAccessProcessors(func(*Record) bool { return true }))
+	p.Access.With(zap.String("client", "203.0.113.57")).Info("hello")
+	if got, want := rec.Access()[0].Fields["client"], newIPAnonymizer(cfg).anonymize("203.0.113.57"); got != want {
+		t.Fatalf("client = %v, want %v", got, want)
+	}
+}
+
+// A file sink and its mirror run their processors once per entry, and both
+// see the With fields
+func TestSinkProcessorsRunOnceForMirror(t *testing.T) {
+	var calls atomic.Int32
+	var mirror bytes.Buffer
+	path := filepath.Join(t.TempDir(), "access.log")
+	p, err := New(WithAccessFile(path, 1, 0, 0, false, Mirror(&mirror), Processors(func(r *Record) bool {
+		calls.Add(1)
+		r.Rename("tenant", "org")
+		return r.Entry.Message != "drop"
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := p.Access.With(zap.String("tenant", "acme"))
+	log.Info("kept")
+	log.Info("drop")
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("processors ran %d times for 2 entries", n)
+	}
+	mirrored := strings.Split(strings.TrimSpace(mirror.String()), "\n")
+	for name, lines := range map[string][]string{"file": readLines(t, path), "mirror": mirrored} {
+		if len(lines) != 1 {
+			t.Fatalf("%s: %q", name, lines)
+		}
+		var e map[string]any
+		if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e["msg"] != "kept" || e["org"] != "acme" || e["tenant"] != nil {
+			t.Errorf("%s entry %v", name, e)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package zlog
+
+// raceEnabled skips allocation counts: sync.Pool drops items at random under
+// the race detector
+const raceEnabled = true
@@ -0,0 +1,123 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// RecordedEntry is an entry captured by a Recorder
+	RecordedEntry struct {
+		Time    time.Time
+		Level   zapcore.Level
+		Logger  string
+		Message string
+		Caller  string
+		Stack   string
+		// Fields holds context and entry fields as decoded from JSON
+		Fields map[string]any
+	}
+
+	// Recorder keeps the entries of a Pair in memory. It is meant for tests;
+	// see WithRecorder and Test.
+	Recorder struct {
+		access recordBuffer
+		error  recordBuffer
+	}
+
+	recordBuffer struct {
+		mu      sync.Mutex
+		entries []RecordedEntry
+	}
+)
+
+// recorder keys, namespaced so that they do not replace fields of the same
+// name in RecordedEntry.Fields
+const (
+	recTimeKey    = "zlog:ts"
+	recLevelKey   = "zlog:level"
+	recLoggerKey  = "zlog:logger"
+	recMessageKey = "zlog:msg"
+	recCallerKey  = "zlog:caller"
+	recStackKey   = "zlog:stacktrace"
+)
+
+// NewRecorder returns an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Access returns a copy of the captured access entries
+func (r *Recorder) Access() []RecordedEntry {
+	return r.access.snapshot()
+}
+
+// Error returns a copy of the captured error entries
+func (r *Recorder) Error() []RecordedEntry {
+	return r.error.snapshot()
+}
+
+// Reset drops all captured entries
+func (r *Recorder) Reset() {
+	r.access.reset()
+	r.error.reset()
+}
+
+func (r *Recorder) encoder() zapcore.Encoder {
+	return zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		TimeKey:        recTimeKey,
+		LevelKey:       recLevelKey,
+		NameKey:        recLoggerKey,
+		MessageKey:     recMessageKey,
+		CallerKey:      recCallerKey,
+		StacktraceKey:  recStackKey,
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	})
+}
+
+func (b *recordBuffer) Write(p []byte) (int, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(p), &raw); err != nil {
+		return 0, err
+	}
+	e := RecordedEntry{Fields: raw}
+	take := func(key string) string {
+		v, _ := raw[key].(string)
+		delete(raw, key)
+		return v
+	}
+	e.Time, _ = time.Parse(time.RFC3339Nano, take(recTimeKey))
+	_ = e.Level.UnmarshalText([]byte(take(recLevelKey)))
+	e.Logger = take(recLoggerKey)
+	e.Message = take(recMessageKey)
+	e.Caller = take(recCallerKey)
+	e.Stack = take(recStackKey)
+
+	b.mu.Lock()
+	b.entries = append(b.entries, e)
+	b.mu.Unlock()
+	return len(p), nil
+}
+
+func (b *recordBuffer) Sync() error  { return nil }
+func (b *recordBuffer) Close() error { return nil }
+
+func (b *recordBuffer) snapshot() []RecordedEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]RecordedEntry(nil), b.entries...)
+}
+
+func (b *recordBuffer) reset() {
+	b.mu.Lock()
+	b.entries = nil
+	b.mu.Unlock()
+}
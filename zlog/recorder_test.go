@@ -0,0 +1,82 @@
+package zlog
+
+import (
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRecorderKeepsUserFields(t *testing.T) {
+	p, rec := Test(t)
+	p.Error.Named("db").Warn("slow query",
+		zap.String("ts", "user ts"),
+		zap.String("level", "user level"),
+		zap.String("msg", "user msg"),
+		zap.String("logger", "user logger"),
+		zap.String("caller", "user caller"),
+		zap.String("stacktrace", "user stacktrace"),
+	)
+
+	entries := rec.Error()
+	if len(entries) != 1 {
+		t.Fatalf("%d entries recorded, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Message != "slow query" || e.Level != zapcore.WarnLevel || e.Logger != "db" || e.Time.IsZero() || e.Caller == "" {
+		t.Errorf("entry = %+v", e)
+	}
+	for _, key := range []string{"ts", "level", "msg", "logger", "caller", "stacktrace"} {
+		if got := e.Fields[key]; got != "user "+key {
+			t.Errorf("field %s = %v, want %q", key, got, "user "+key)
+		}
+	}
+}
+
+// fakeTB records Logf calls and fails like testing does once the test ended
+type fakeTB struct {
+	logs     []string
+	cleanups []func()
+	ended    bool
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Logf(format string, args ...any) {
+	if f.ended {
+		panic("Log in goroutine after test has completed")
+	}
+	f.logs = append(f.logs, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Fatalf(format string, args ...any) { panic(fmt.Sprintf(format, args...)) }
+
+func (f *fakeTB) Cleanup(fn func()) { f.cleanups = append(f.cleanups, fn) }
+
+func (f *fakeTB) end() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+	f.ended = true
+}
+
+func TestTestLoggingAfterEnd(t *testing.T) {
+	tb := &fakeTB{}
+	p, rec := Test(tb)
+	p.Access.Info("during")
+	if len(tb.logs) != 1 {
+		t.Fatalf("%d lines logged to the test, want 1", len(tb.logs))
+	}
+	tb.end()
+
+	// a goroutine outliving the test must not reach t.Logf
+	p.Access.Info("after")
+	p.Error.Error("after")
+	if len(tb.logs) != 1 {
+		t.Fatalf("%d lines logged to the test, want 1", len(tb.logs))
+	}
+	if n := len(rec.Access()); n != 2 {
+		t.Fatalf("%d access entries recorded, want 2", n)
+	}
+}
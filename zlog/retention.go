@@ -0,0 +1,169 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// RetentionTier compacts backups older than After: only entries at
+	// MinLevel or above, and accepted by Keep when it is set, are kept.
+	// Entries whose level cannot be read are kept.
+	RetentionTier struct {
+		After    time.Duration
+		MinLevel zapcore.Level
+		// Keep filters on the encoded entry, without its line ending
+		Keep func(entry []byte) bool
+	}
+
+	// retentionState remembers the tier each backup was last compacted to, so
+	// backups are rewritten once per tier
+	retentionState map[string]int
+)
+
+const retentionSuffix = ".retention"
+
+// sortTiers orders tiers by age, youngest first
+func sortTiers(tiers []RetentionTier) []RetentionTier {
+	tiers = append([]RetentionTier(nil), tiers...)
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].After < tiers[j].After })
+	return tiers
+}
+
+// retentionInterval is how often backups are checked against tiers sorted
+// by sortTiers: a tenth of the youngest tier's age, within a minute and an
+// hour
+func retentionInterval(tiers []RetentionTier) time.Duration {
+	return min(time.Hour, max(time.Minute, tiers[0].After/10))
+}
+
+// tierFor returns the 1-based index of the oldest tier that applies to a
+// backup of the given age, 0 if none does
+func tierFor(tiers []RetentionTier, age time.Duration) int {
+	tier := 0
+	for i, t := range tiers {
+		if age >= t.After {
+			tier = i + 1
+		}
+	}
+	return tier
+}
+
+// keepFunc combines the level and filter of a tier
+func (t RetentionTier) keepFunc(levelOf func([]byte) (zapcore.Level, bool)) func([]byte) (bool, error) {
+	return func(line []byte) (bool, error) {
+		if levelOf != nil {
+			if lvl, ok := levelOf(line); ok && lvl < t.MinLevel {
+				return false, nil
+			}
+		}
+		return t.Keep == nil || t.Keep(line), nil
+	}
+}
+
+// entryLevelFunc reads the level of an encoded entry: the levelKey field of
+// JSON entries, or the first tab-separated column naming a level in console
+// entries
+func entryLevelFunc(encoding, levelKey string) func([]byte) (zapcore.Level, bool) {
+	if levelKey == "" {
+		return nil
+	}
+	if encoding == EncodingConsole {
+		return func(line []byte) (zapcore.Level, bool) {
+			for _, col := range bytes.SplitN(line, []byte{'\t'}, 4) {
+				var lvl zapcore.Level
+				if lvl.UnmarshalText(col) == nil {
+					return lvl, true
+				}
+			}
+			return 0, false
+		}
+	}
+	return func(line []byte) (zapcore.Level, bool) {
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(line, &obj) != nil {
+			return 0, false
+		}
+		var s string
+		if json.Unmarshal(obj[levelKey], &s) != nil {
+			return 0, false
+		}
+		var lvl zapcore.Level
+		if lvl.UnmarshalText([]byte(s)) != nil {
+			return 0, false
+		}
+		return lvl, true
+	}
+}
+
+func loadRetentionState(name string) (retentionState, error) {
+	st := retentionState{}
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return st, nil
+	} else if err != nil {
+		return st, err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return st, nil
+	}
+	return st, json.Unmarshal(b, &st)
+}
+
+// save atomically replaces the state file, dropping backups that are gone
+func (st retentionState) save(name string) error {
+	dir := filepath.Dir(name)
+	for backup := range st {
+		if !backupExists(filepath.Join(dir, backup)) {
+			delete(st, backup)
+		}
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// compact rewrites the backups that entered a new retention tier. It returns
+// the rewritten backups for the manifest.
+func (w *rotateWriter) compact(backups []backupFile) (map[string]rewriteResult, error) {
+	c := w.cfg
+	stateFile := c.Path + retentionSuffix
+	st, err := loadRetentionState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(c.Path)
+	now := time.Now()
+	results := map[string]rewriteResult{}
+	var errs []error
+	for _, b := range backups {
+		tier := tierFor(c.Retention, now.Sub(b.time))
+		if tier <= st[b.name] {
+			continue
+		}
+		res, err := rewriteLog(filepath.Join(dir, b.name), b.gz, c.Retention[tier-1].keepFunc(c.LevelOf), c.TimeOf, false)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		st[b.name] = tier
+		if res.removed > 0 {
+			results[b.name] = res
+		}
+	}
+	errs = append(errs, st.save(stateFile))
+	return results, errors.Join(errs...)
+}
@@ -0,0 +1,106 @@
+package zlog
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRetentionInterval(t *testing.T) {
+	for _, tc := range []struct {
+		youngest, want time.Duration
+	}{
+		{time.Second, time.Minute},
+		{30 * time.Minute, 3 * time.Minute},
+		{7 * 24 * time.Hour, time.Hour},
+	} {
+		tiers := sortTiers([]RetentionTier{{After: 100 * 24 * time.Hour}, {After: tc.youngest}})
+		if got := retentionInterval(tiers); got != tc.want {
+			t.Errorf("youngest tier %v: interval %v, want %v", tc.youngest, got, tc.want)
+		}
+	}
+}
+
+func TestTierFor(t *testing.T) {
+	tiers := sortTiers([]RetentionTier{{After: 30 * time.Hour}, {After: time.Hour}})
+	for age, want := range map[time.Duration]int{0: 0, time.Hour: 1, 29 * time.Hour: 1, 31 * time.Hour: 2} {
+		if got := tierFor(tiers, age); got != want {
+			t.Errorf("age %v: tier %d, want %d", age, got, want)
+		}
+	}
+}
+
+func TestRetentionKeepFunc(t *testing.T) {
+	tier := RetentionTier{MinLevel: zapcore.WarnLevel, Keep: func(e []byte) bool { return !bytes.Contains(e, []byte("health")) }}
+	keep := tier.keepFunc(entryLevelFunc(EncodingJSON, "level"))
+	for line, want := range map[string]bool{
+		`{"level":"info","msg":"a"}`:       false,
+		`{"level":"error","msg":"a"}`:      true,
+		`{"level":"error","msg":"health"}`: false,
+		`{"msg":"no level"}`:               true,
+	} {
+		if got, err := keep([]byte(line)); got != want || err != nil {
+			t.Errorf("%s: keep = %v, %v", line, got, err)
+		}
+	}
+}
+
+// A sink compacts the backups left by a previous run without being written to
+func TestRetentionCompactsAtStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	cfg := rotateCfg{
+		Path:     path,
+		Manifest: true,
+		LevelOf:  entryLevelFunc(EncodingJSON, "level"),
+		TimeOf:   entryTimeFunc(EncodingJSON, "ts"),
+	}
+	w := newFileRotator(cfg)
+	for i, lvl := range []string{"info", "warn", "error", "info"} {
+		if _, err := fmt.Fprintf(w, `{"level":%q,"ts":"2024-05-06T%02d:00:00.000Z"}`+"\n", lvl, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Retention = sortTiers([]RetentionTier{{After: time.Nanosecond, MinLevel: zapcore.WarnLevel}})
+	w = newFileRotator(cfg)
+	defer w.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, err := loadRetentionState(path + retentionSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(st) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("backup not compacted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	w.Close()
+
+	if lines := logLines(t, path); len(lines) != 2 {
+		t.Fatalf("kept %q, want the warn and error entries", lines)
+	}
+	if bad, err := VerifyManifest(ManifestPath(path)); err != nil || len(bad) > 0 {
+		t.Fatalf("VerifyManifest = %v, %v", bad, err)
+	}
+	entries, err := ReadManifest(ManifestPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := entries[0]; e.Entries != 2 || e.First.Hour() != 1 || e.Last.Hour() != 2 {
+		t.Fatalf("manifest entry %+v, want 2 entries from 01:00 to 02:00", e)
+	}
+}
@@ -0,0 +1,565 @@
+package zlog
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// rotateWriter is a size-rotating log file compatible with lumberjack's
+// options and backup naming (name-2006-01-02T15-04-05.000.ext[.gz]).
+//
+// Concurrent writes are group committed: each Write appends its entry to a
+// pending buffer, and one writer at a time (the leader) takes the whole
+// buffer and writes it with a single syscall while the others keep appending
+// to the next batch. Rotation (close, rename, reopen) happens in the leader
+// without holding the append lock. Compression and cleanup of backups, and
+// the fsync of rotated files, run in a background goroutine.
+type rotateWriter struct {
+	cfg rotateCfg
+	max int64
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	pending   []byte
+	lens      []int // length of every entry in pending
+	spare     []byte
+	spareLens []int
+	open      uint64 // batch accepting new entries
+	done      uint64 // last batch written
+	flushing  bool
+	batch     *flushBatch   // outcome of the open batch
+	free      []*flushBatch // batches no writer waits for any more
+	closed    bool
+
+	// fileMu is held by the leader while writing or rotating, and by Sync
+	fileMu     sync.Mutex
+	file       *os.File
+	size       int64
+	seg        *segment // manifest bookkeeping, nil when disabled
+	fileClosed bool     // set by Close: a late flush must not reopen the file
+
+	// retired lists rotated files not fsynced yet, with the last error of
+	// syncing them; drainMu serializes syncRetired
+	retiredMu  sync.Mutex
+	retired    []string
+	retiredErr error
+	drainMu    sync.Mutex
+
+	manifestMu sync.Mutex
+
+	millOnce sync.Once
+	millCh   chan struct{}
+	millStop chan struct{}
+	millDone chan struct{}
+}
+
+const (
+	// spareLimit caps the batch buffer kept for reuse after a burst
+	spareLimit = 1 << 20
+)
+
+// flushBatch is the outcome of one batch, held by the writers waiting for it
+type flushBatch struct {
+	err     error
+	waiters int
+}
+
+func newFileRotator(c rotateCfg) *rotateWriter {
+	w := &rotateWriter{
+		cfg:      c,
+		max:      maxBytes(c.MaxSizeMB),
+		open:     1,
+		batch:    new(flushBatch),
+		millCh:   make(chan struct{}, 1),
+		millStop: make(chan struct{}),
+		millDone: make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	if c.Manifest {
+		w.seg = newSegment()
+	}
+	if len(c.Retention) > 0 {
+		// backups age whether or not anything is written
+		w.millOnce.Do(func() { go w.millRun() })
+		w.signalMill()
+	}
+	return w
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	if err := w.WriteEntries(p, []int{len(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteEntries writes several entries laid out back to back in buf, lens
+// giving their sizes, as a single batch
+func (w *rotateWriter) WriteEntries(buf []byte, lens []int) error {
+	for _, n := range lens {
+		if int64(n) > w.max {
+			return fmt.Errorf("zlog: write length %d exceeds maximum file size %d", n, w.max)
+		}
+	}
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return os.ErrClosed
+	}
+	w.pending = append(w.pending, buf...)
+	w.lens = append(w.lens, lens...)
+	batch, b := w.open, w.batch
+	b.waiters++
+	for w.done < batch {
+		if w.flushing {
+			w.cond.Wait()
+			continue
+		}
+		w.flush()
+	}
+	err := b.err
+	b.waiters--
+	w.release(b)
+	w.mu.Unlock()
+	return err
+}
+
+// flush writes the open batch. Must be called with w.mu held and no flush
+// in progress; w.mu is released during I/O.
+func (w *rotateWriter) flush() {
+	w.flushing = true
+	batch, b := w.open, w.batch
+	w.open++
+	w.batch = w.newBatch()
+	buf, lens := w.pending, w.lens
+	w.pending, w.lens = w.spare[:0], w.spareLens[:0]
+	w.mu.Unlock()
+
+	err := w.writeBatch(buf, lens)
+
+	w.mu.Lock()
+	if cap(buf) <= spareLimit {
+		w.spare, w.spareLens = buf[:0], lens[:0]
+	} else {
+		w.spare, w.spareLens = nil, nil
+	}
+	w.done = batch
+	b.err = err
+	w.release(b)
+	w.flushing = false
+	w.cond.Broadcast()
+}
+
+// newBatch returns a flushBatch for the next batch. Must hold w.mu.
+func (w *rotateWriter) newBatch() *flushBatch {
+	if n := len(w.free); n > 0 {
+		b := w.free[n-1]
+		w.free = w.free[:n-1]
+		return b
+	}
+	return new(flushBatch)
+}
+
+// release recycles a written batch once no writer waits for it. Must hold
+// w.mu.
+func (w *rotateWriter) release(b *flushBatch) {
+	if b.waiters == 0 && b != w.batch {
+		*b = flushBatch{}
+		w.free = append(w.free, b)
+	}
+}
+
+// writeBatch writes entries to the file, rotating between entries whenever
+// the next one would push the file over its maximum size
+func (w *rotateWriter) writeBatch(buf []byte, lens []int) error {
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+
+	if w.fileClosed {
+		return os.ErrClosed
+	}
+	if w.file == nil {
+		if err := w.openExistingOrNew(); err != nil {
+			return err
+		}
+	}
+
+	var start, chunk, entries int
+	for _, n := range lens {
+		if w.size+int64(chunk+n) > w.max && w.size+int64(chunk) > 0 {
+			if err := w.writeChunk(buf[start:start+chunk], entries); err != nil {
+				return err
+			}
+			if err := w.rotate(); err != nil {
+				return err
+			}
+			start, chunk, entries = start+chunk, 0, 0
+		}
+		chunk += n
+		entries++
+	}
+	return w.writeChunk(buf[start:start+chunk], entries)
+}
+
+func (w *rotateWriter) writeChunk(b []byte, entries int) error {
+	if len(b) == 0 {
+		return nil
+	}
+	n, err := w.file.Write(b)
+	w.size += int64(n)
+	if w.seg != nil {
+		w.seg.add(b[:n], entries)
+	}
+	return err
+}
+
+// openExistingOrNew appends to the log file if it exists. Must hold fileMu.
+func (w *rotateWriter) openExistingOrNew() error {
+	w.millOnce.Do(func() { go w.millRun() })
+	w.signalMill()
+
+	if err := os.MkdirAll(filepath.Dir(w.cfg.Path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.cfg.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if os.IsNotExist(err) {
+		return w.openNew(0o600)
+	} else if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, info.Size()
+	if w.seg != nil {
+		return w.seg.load(w.cfg.Path, w.cfg.TimeOf)
+	}
+	return nil
+}
+
+func (w *rotateWriter) openNew(mode os.FileMode) error {
+	f, err := os.OpenFile(w.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	w.file, w.size = f, 0
+	return nil
+}
+
+// Rotate closes the active file into a backup and starts a new one
+func (w *rotateWriter) Rotate() error {
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+	if w.fileClosed {
+		return os.ErrClosed
+	}
+	if w.file == nil {
+		if err := w.openExistingOrNew(); err != nil {
+			return err
+		}
+	}
+	return w.rotate()
+}
+
+// rotate must hold fileMu with the file open. The old file is fsynced later,
+// off the write path, by the mill or by the next Sync, so Sync keeps its
+// meaning across rotations.
+func (w *rotateWriter) rotate() error {
+	mode := os.FileMode(0o600)
+	if info, err := w.file.Stat(); err == nil {
+		mode = info.Mode()
+	}
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		return err
+	}
+
+	backup := w.backupName()
+	if err := os.Rename(w.cfg.Path, backup); err != nil {
+		return err
+	}
+	w.retiredMu.Lock()
+	w.retired = append(w.retired, backup)
+	w.retiredMu.Unlock()
+	if err := w.openNew(mode); err != nil {
+		return err
+	}
+	if w.seg != nil {
+		entry := w.seg.entry(filepath.Base(backup))
+		w.seg.reset()
+		if err := w.appendManifest(entry); err != nil {
+			return err
+		}
+	}
+	w.signalMill()
+	return nil
+}
+
+// backupName returns a free backup name for the current time
+func (w *rotateWriter) backupName() string {
+	dir := filepath.Dir(w.cfg.Path)
+	base := filepath.Base(w.cfg.Path)
+	ext := filepath.Ext(base)
+	prefix := base[:len(base)-len(ext)]
+
+	t := time.Now()
+	if !w.cfg.LocalTime {
+		t = t.UTC()
+	}
+	for {
+		name := filepath.Join(dir, prefix+"-"+t.Format(backupTimeFormat)+ext)
+		if !backupExists(name) {
+			return name
+		}
+		// two rotations within a millisecond: never overwrite a backup
+		t = t.Add(time.Millisecond)
+	}
+}
+
+func (w *rotateWriter) appendManifest(e ManifestEntry) error {
+	w.manifestMu.Lock()
+	defer w.manifestMu.Unlock()
+	manifest := ManifestPath(w.cfg.Path)
+	known, err := ReadManifest(manifest)
+	if err != nil {
+		return err
+	}
+	return writeManifest(manifest, append(known, e))
+}
+
+// Sync writes out pending entries and fsyncs the file
+func (w *rotateWriter) Sync() error {
+	w.mu.Lock()
+	target := w.open
+	if len(w.pending) == 0 {
+		target--
+	}
+	for w.done < target {
+		if w.flushing {
+			w.cond.Wait()
+			continue
+		}
+		w.flush()
+	}
+	w.mu.Unlock()
+
+	w.syncRetired()
+	retiredErr := w.takeRetiredErr()
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+	if w.file == nil {
+		return retiredErr
+	}
+	return errors.Join(retiredErr, w.file.Sync())
+}
+
+// syncRetired fsyncs the files rotated since the last call, and their
+// directory. Failures are kept for Sync to report, since the mill usually
+// gets there first.
+func (w *rotateWriter) syncRetired() {
+	w.drainMu.Lock()
+	defer w.drainMu.Unlock()
+	w.retiredMu.Lock()
+	names := w.retired
+	w.retired = nil
+	w.retiredMu.Unlock()
+	if len(names) == 0 {
+		return
+	}
+
+	errs := []error{w.retiredErr}
+	for _, name := range names {
+		errs = append(errs, syncFile(name))
+	}
+	errs = append(errs, syncDir(filepath.Dir(w.cfg.Path)))
+	w.retiredErr = errors.Join(errs...)
+}
+
+// takeRetiredErr returns and clears the failures of syncRetired
+func (w *rotateWriter) takeRetiredErr() error {
+	w.drainMu.Lock()
+	defer w.drainMu.Unlock()
+	err := w.retiredErr
+	w.retiredErr = nil
+	return err
+}
+
+// syncFile fsyncs a closed file. A file already gone was compressed, and
+// compressFile syncs its copy.
+func syncFile(name string) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return errors.Join(f.Sync(), f.Close())
+}
+
+// Close flushes and closes the file and waits for background cleanup
+func (w *rotateWriter) Close() error {
+	err := w.Sync()
+
+	w.mu.Lock()
+	already := w.closed
+	w.closed = true
+	w.mu.Unlock()
+	if already {
+		return nil
+	}
+
+	w.fileMu.Lock()
+	w.fileClosed = true
+	if w.file != nil {
+		if cerr := w.file.Close(); err == nil {
+			err = cerr
+		}
+		w.file = nil
+	}
+	w.fileMu.Unlock()
+
+	// stop the cleanup goroutine, or make sure it never starts
+	started := true
+	w.millOnce.Do(func() { started = false })
+	if started {
+		close(w.millStop)
+		<-w.millDone
+	}
+	// files rotated by flushes that raced with Close
+	w.syncRetired()
+	return errors.Join(err, w.takeRetiredErr())
+}
+
+func (w *rotateWriter) signalMill() {
+	select {
+	case w.millCh <- struct{}{}:
+	default:
+	}
+}
+
+// millRun compresses and removes backups off the write path. With
+// retention tiers it also runs periodically, as backups enter a tier.
+func (w *rotateWriter) millRun() {
+	defer close(w.millDone)
+	var tick <-chan time.Time
+	if len(w.cfg.Retention) > 0 {
+		t := time.NewTicker(retentionInterval(w.cfg.Retention))
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-w.millStop:
+			return
+		case <-w.millCh:
+			_ = w.millRunOnce()
+		case <-tick:
+			_ = w.millRunOnce()
+		}
+	}
+}
+
+func (w *rotateWriter) millRunOnce() error {
+	// make rotated files durable before compressing or removing them
+	w.syncRetired()
+	c := w.cfg
+	if c.MaxBackups == 0 && c.MaxAgeDays == 0 && !c.Compress && !c.Manifest && len(c.Retention) == 0 {
+		return nil
+	}
+	// Erase may be rewriting the same backups
+	unlock, err := lockFile(c.Path + lockSuffix)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	backups, err := listBackups(c.Path, c.LocalTime)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(c.Path)
+
+	var errs []error
+	keep := backups[:0]
+	cutoff := time.Now().Add(-time.Duration(c.MaxAgeDays) * 24 * time.Hour)
+	for i, b := range backups {
+		tooMany := c.MaxBackups > 0 && len(backups)-i > c.MaxBackups
+		tooOld := c.MaxAgeDays > 0 && b.time.Before(cutoff)
+		if !tooMany && !tooOld {
+			keep = append(keep, b)
+			continue
+		}
+		for _, name := range []string{b.name, b.name + compressSuffix} {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	var compacted map[string]rewriteResult
+	if len(c.Retention) > 0 {
+		var err error
+		compacted, err = w.compact(keep)
+		errs = append(errs, err)
+	}
+
+	if c.Compress {
+		for _, b := range keep {
+			if !b.gz {
+				errs = append(errs, compressFile(filepath.Join(dir, b.name)))
+			}
+		}
+	}
+
+	if c.Manifest {
+		w.manifestMu.Lock()
+		manifest := ManifestPath(c.Path)
+		known, err := ReadManifest(manifest)
+		if err == nil {
+			applyRewrites(known, compacted)
+			err = writeManifest(manifest, known)
+		}
+		w.manifestMu.Unlock()
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// compressFile gzips name into name.gz, then removes name
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := name + compressSuffix + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	err = errors.Join(err, zw.Close(), dst.Sync(), dst.Close())
+	if err == nil {
+		err = os.Rename(tmp, name+compressSuffix)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,283 @@
+package zlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// logLines returns the lines of the active file and of every backup,
+// decompressing gzipped ones
+func logLines(t *testing.T, path string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(strings.TrimSuffix(path, filepath.Ext(path)) + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, name := range matches {
+		if strings.HasSuffix(name, manifestSuffix) || strings.HasSuffix(name, lockSuffix) || strings.HasSuffix(name, retentionSuffix) {
+			continue
+		}
+		lines = append(lines, readLines(t, name)...)
+	}
+	return lines
+}
+
+// readLines returns the non-empty lines of one file, decompressing it if it
+// is gzipped; a missing file has none
+func readLines(t *testing.T, name string) []string {
+	t.Helper()
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, compressSuffix) {
+		if r, err = gzip.NewReader(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, l := range strings.Split(string(b), "\n") {
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w := newFileRotator(rotateCfg{Path: path, Manifest: true})
+	w.max = 100
+	line := strings.Repeat("x", 29) + "\n" // 30 bytes, 3 per file
+	for range 10 {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := listBackups(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("%d backups, want 3", len(backups))
+	}
+	if n := len(logLines(t, path)); n != 10 {
+		t.Fatalf("%d lines in all files, want 10", n)
+	}
+	entries, err := ReadManifest(ManifestPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Entries != 3 || e.Size != 90 {
+			t.Errorf("manifest entry %+v, want 3 entries of 90 bytes", e)
+		}
+	}
+	if _, err := w.Write([]byte("late\n")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Write after Close = %v, want os.ErrClosed", err)
+	}
+}
+
+func TestRotateConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w := newFileRotator(rotateCfg{Path: path, Compress: true, MaxBackups: 1000})
+	w.max = 4 << 10
+	const writers, perWriter = 8, 300
+	var wg sync.WaitGroup
+	for g := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perWriter {
+				if _, err := fmt.Fprintf(w, "writer=%d entry=%04d\n", g, i); err != nil {
+					t.Error(err)
+					return
+				}
+				if i%100 == 0 {
+					if err := w.Sync(); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := logLines(t, path)
+	if len(lines) != writers*perWriter {
+		t.Fatalf("%d lines, want %d", len(lines), writers*perWriter)
+	}
+	sort.Strings(lines)
+	for i, l := range lines {
+		if want := fmt.Sprintf("writer=%d entry=%04d", i/perWriter, i%perWriter); l != want {
+			t.Fatalf("line %d = %q, want %q", i, l, want)
+		}
+	}
+}
+
+func TestRotateRestartAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	for run := range 2 {
+		w := newFileRotator(rotateCfg{Path: path, Manifest: true})
+		for i := range 3 {
+			if _, err := fmt.Fprintf(w, "run=%d entry=%d\n", run, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := newFileRotator(rotateCfg{Path: path, Manifest: true})
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadManifest(ManifestPath(path))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ReadManifest = %v, %v", entries, err)
+	}
+	if entries[0].Entries != 6 {
+		t.Fatalf("backup has %d entries, want the 6 of both runs", entries[0].Entries)
+	}
+	if bad, err := VerifyManifest(ManifestPath(path)); err != nil || len(bad) > 0 {
+		t.Fatalf("VerifyManifest = %v, %v", bad, err)
+	}
+}
+
+// A line longer than any scanner buffer is still counted and hashed
+func TestSegmentLoadLongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	long := `{"ts":"2024-05-06T07:08:09.000Z","msg":"` + strings.Repeat("y", 3<<20) + "\"}\n"
+	content := "short\n" + long + `{"ts":"2024-05-06T08:00:00.000Z"}` // last one unterminated
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := newSegment()
+	if err := s.load(path, entryTimeFunc(EncodingJSON, "ts")); err != nil {
+		t.Fatal(err)
+	}
+	if s.entries != 3 || s.size != int64(len(content)) {
+		t.Fatalf("entries=%d size=%d, want 3 and %d", s.entries, s.size, len(content))
+	}
+	if s.last.Hour() != 8 {
+		t.Fatalf("last = %v, want the time of the unterminated entry", s.last)
+	}
+}
+
+func TestLineCounter(t *testing.T) {
+	for _, tc := range []struct {
+		in          string
+		lines       int64
+		first, last string
+	}{
+		{"", 0, "", ""},
+		{"a", 1, "a", "a"},
+		{"a\n", 1, "a", "a"},
+		{"a\nb\nc", 3, "a", "c"},
+		{"a\n\nb\n", 3, "a", "b"},
+	} {
+		var l lineCounter
+		// split writes at every byte
+		for i := range len(tc.in) {
+			l.Write([]byte{tc.in[i]})
+		}
+		if l.count() != tc.lines || string(l.head()) != tc.first || string(l.last()) != tc.last {
+			t.Errorf("%q: count=%d head=%q last=%q", tc.in, l.count(), l.head(), l.last())
+		}
+	}
+	var l lineCounter
+	l.Write(bytes.Repeat([]byte("z"), 2*lineHead))
+	if len(l.head()) != lineHead {
+		t.Errorf("head keeps %d bytes, want %d", len(l.head()), lineHead)
+	}
+}
+
+// A flush racing with Close must not reopen the file
+func TestRotateFlushAfterClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w := newFileRotator(rotateCfg{Path: path})
+	if _, err := w.Write([]byte("a\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeBatch([]byte("b\n"), []int{2}); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("writeBatch after Close = %v, want os.ErrClosed", err)
+	}
+	if err := w.Rotate(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Rotate after Close = %v, want os.ErrClosed", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file reopened after Close: %v", err)
+	}
+}
+
+// Every writer of a failed batch gets its error, however many batches fail
+// meanwhile, and failed batches are not kept around
+func TestRotateWriteErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "logs"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// the directory cannot be created: every batch fails
+	w := newFileRotator(rotateCfg{Path: filepath.Join(dir, "logs", "app.log")})
+	const writers, perWriter = 8, 200
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perWriter {
+				if _, err := w.Write([]byte("entry\n")); err == nil {
+					succeeded.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := succeeded.Load(); n != 0 {
+		t.Fatalf("%d failed writes reported success", n)
+	}
+	w.mu.Lock()
+	kept := len(w.free)
+	w.mu.Unlock()
+	if kept > writers {
+		t.Fatalf("%d batches kept after the writes returned", kept)
+	}
+	w.Close()
+}
@@ -0,0 +1,84 @@
+package zlog
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestErrorFileWithRoutes(t *testing.T) {
+	for _, order := range []string{"file first", "routes first"} {
+		t.Run(order, func(t *testing.T) {
+			dir := t.TempDir()
+			file := WithErrorFile(filepath.Join(dir, "error.log"), 1, 0, 0, false)
+			route := WithErrorRoute(zapcore.WarnLevel, zapcore.WarnLevel, filepath.Join(dir, "warn.log"), 1, 0, 0, false)
+			opts := []Option{file, route}
+			if order == "routes first" {
+				opts = []Option{route, file}
+			}
+			p, err := New(append(opts, WithInitialLevels(zapcore.InfoLevel, zapcore.WarnLevel))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Error.Warn("w")
+			p.Error.Error("e")
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(readLines(t, filepath.Join(dir, "error.log"))); n != 2 {
+				t.Errorf("error.log has %d entries, want 2", n)
+			}
+			if n := len(readLines(t, filepath.Join(dir, "warn.log"))); n != 1 {
+				t.Errorf("warn.log has %d entries, want 1", n)
+			}
+		})
+	}
+}
+
+func TestErrorRouteBelowLevelWarns(t *testing.T) {
+	var warned bytes.Buffer
+	defer func(w io.Writer) { warnOutput = w }(warnOutput)
+	warnOutput = &warned
+
+	dir := t.TempDir()
+	p, err := New(
+		WithErrorRoute(zapcore.WarnLevel, zapcore.WarnLevel, filepath.Join(dir, "warn.log"), 1, 0, 0, false),
+		WithErrorRoute(zapcore.ErrorLevel, zapcore.FatalLevel, filepath.Join(dir, "error.log"), 1, 0, 0, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	out := warned.String()
+	if !strings.Contains(out, "warn.log") {
+		t.Errorf("no warning for warn.log below the error level: %q", out)
+	}
+	if strings.Contains(out, "error.log") {
+		t.Errorf("unexpected warning for error.log: %q", out)
+	}
+}
+
+func TestSinkNamesUniqueAcrossLoggers(t *testing.T) {
+	dir := t.TempDir()
+	p, err := New(
+		WithAccessFile(filepath.Join(dir, "access.log"), 1, 0, 0, false, SinkName("app")),
+		WithErrorFile(filepath.Join(dir, "error.log"), 1, 0, 0, false, SinkName("app")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	names := p.SinkNames()
+	if len(names) != 2 || names[0] != "app" || names[1] != "app#2" {
+		t.Fatalf("SinkNames = %v, want [app app#2]", names)
+	}
+	// the levels are independent
+	p.SinkLevels["app#2"].SetLevel(zapcore.FatalLevel)
+	if p.SinkLevels["app"].Level() == zapcore.FatalLevel {
+		t.Fatal("setting app#2 changed app")
+	}
+}
@@ -0,0 +1,74 @@
+package zlog
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// sensitiveValue is masked unless a trusted sink reveals it
+type sensitiveValue struct {
+	key   string
+	value any
+}
+
+// sensitiveMask replaces sensitive values on sinks that are not trusted
+const sensitiveMask = "[REDACTED]"
+
+// Sensitive returns a field whose real value is only written to sinks marked
+// with Trusted. Other sinks get "[REDACTED]", or a keyed hash when
+// WithSensitiveHash is set. Only top-level fields are revealed: a Sensitive
+// field nested in an object or array is always masked.
+func Sensitive(key string, value any) zap.Field {
+	return zap.Field{Key: key, Type: zapcore.InlineMarshalerType, Interface: &sensitiveValue{key: key, value: value}}
+}
+
+// MarshalLogObject renders the masked form, used wherever no sink rewrote it
+func (s *sensitiveValue) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString(s.key, sensitiveMask)
+	return nil
+}
+
+// sensitiveRenderer rewrites sensitive fields for one sink
+type sensitiveRenderer func(*sensitiveValue) zap.Field
+
+func revealSensitive(s *sensitiveValue) zap.Field {
+	return zap.Any(s.key, s.value)
+}
+
+// hashSensitive renders values as a truncated HMAC-SHA256, so equal values
+// can still be correlated without being readable
+func hashSensitive(secret []byte) sensitiveRenderer {
+	return func(s *sensitiveValue) zap.Field {
+		m := hmac.New(sha256.New, secret)
+		fmt.Fprint(m, s.value)
+		return zap.String(s.key, "hmac:"+hex.EncodeToString(m.Sum(nil)[:8]))
+	}
+}
+
+// renderSensitive returns fields with sensitive values rendered by r. The
+// input is returned as is, without copying, when it holds none.
+func renderSensitive(fields []zapcore.Field, r sensitiveRenderer) []zapcore.Field {
+	if r == nil {
+		return fields
+	}
+	var out []zapcore.Field
+	for i := range fields {
+		s, ok := fields[i].Interface.(*sensitiveValue)
+		if !ok || fields[i].Type != zapcore.InlineMarshalerType {
+			continue
+		}
+		if out == nil {
+			out = append(make([]zapcore.Field, 0, len(fields)), fields...)
+		}
+		out[i] = r(s)
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSensitiveTrustedAndMasked(t *testing.T) {
+	trusted := filepath.Join(t.TempDir(), "audit.log")
+	var mirror, console bytes.Buffer
+	p, err := New(
+		WithAccessFile(trusted, 1, 0, 0, false, Trusted(), Mirror(&mirror)),
+		WithAccessConsole(&console, zapcore.DebugLevel, zapcore.FatalLevel),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Access.With(Sensitive("token", "t0k")).Info("payment", Sensitive("card", "4111"), zap.String("order", "42"))
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		line        string
+		card, token string
+	}{
+		{"trusted file", readLines(t, trusted)[0], "4111", "t0k"},
+		{"mirror", strings.TrimSpace(mirror.String()), sensitiveMask, sensitiveMask},
+		{"console", strings.TrimSpace(console.String()), sensitiveMask, sensitiveMask},
+	} {
+		var got map[string]any
+		if err := json.Unmarshal([]byte(tc.line), &got); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got["card"] != tc.card || got["token"] != tc.token || got["order"] != "42" {
+			t.Errorf("%s: card=%v token=%v order=%v, want %s, %s and 42", tc.name, got["card"], got["token"], got["order"], tc.card, tc.token)
+		}
+	}
+}
+
+func TestSensitiveHash(t *testing.T) {
+	p, rec := Test(t, WithSensitiveHash([]byte("secret")))
+	p.Access.Info("a", Sensitive("card", "4111"))
+	p.Access.Info("b", Sensitive("card", "4111"))
+	p.Access.Info("c", Sensitive("card", "4242"))
+
+	entries := rec.Access()
+	cards := make([]string, len(entries))
+	for i, e := range entries {
+		cards[i], _ = e.Fields["card"].(string)
+		if !strings.HasPrefix(cards[i], "hmac:") || strings.Contains(cards[i], "4111") {
+			t.Fatalf("card = %q, want an hmac", cards[i])
+		}
+	}
+	if cards[0] != cards[1] || cards[0] == cards[2] {
+		t.Fatalf("hashes %q do not correlate equal values only", cards)
+	}
+
+	other := hashSensitive([]byte("other"))(&sensitiveValue{key: "card", value: "4111"})
+	if other.String == cards[0] {
+		t.Fatal("hash does not depend on the secret")
+	}
+}
+
+func TestSensitiveNestedAlwaysMasked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	p, err := New(WithAccessFile(path, 1, 0, 0, false, Trusted()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Access.Info("payment", zap.Object("payment", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		return enc.AddObject("card", Sensitive("number", "4111").Interface.(zapcore.ObjectMarshaler))
+	})))
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	line := readLines(t, path)[0]
+	if strings.Contains(line, "4111") || !strings.Contains(line, sensitiveMask) {
+		t.Fatalf("nested sensitive value written: %s", line)
+	}
+}
+
+func TestRenderSensitiveNoCopy(t *testing.T) {
+	fields := []zap.Field{zap.String("a", "b")}
+	if out := renderSensitive(fields, revealSensitive); &out[0] != &fields[0] {
+		t.Fatal("fields without sensitive values were copied")
+	}
+	fields = append(fields, Sensitive("k", "v"))
+	out := renderSensitive(fields, revealSensitive)
+	if out[1].Type != zapcore.StringType || fields[1].Type != zapcore.InlineMarshalerType {
+		t.Fatalf("rendered %v, input %v", out[1], fields[1])
+	}
+}
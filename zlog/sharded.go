@@ -0,0 +1,231 @@
+package zlog
+
+import (
+	"errors"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// shardedWriter spreads writers over independently locked buffers, each
+	// write going to a shard picked at random, and lets a single goroutine
+	// move batches to the file, so concurrent loggers rarely contend.
+	//
+	// Ordering: every buffered entry gets a sequence number, internal to the
+	// writer. The flusher locks all shards at once to take a snapshot and
+	// writes it sorted by sequence number, so entries of one goroutine keep
+	// their order. Entries of different goroutines are ordered exactly within
+	// a batch and approximately across batches.
+	//
+	// A shard holds at most shardMaxBytes: a writer finding its shard full
+	// flushes all shards itself. Errors of background flushes are reported by
+	// the next Sync or Close.
+	shardedWriter struct {
+		next   fileWriter
+		shards []shard
+		seq    atomic.Uint64
+
+		// flushMu serializes flushes from the flusher goroutine, writers,
+		// Sync and Close
+		flushMu sync.Mutex
+		recs    []shardRec
+		out     []byte
+		lens    []int
+		err     error // of flushes nobody waited for
+
+		kick chan struct{}
+		stop chan struct{}
+		done chan struct{}
+		once sync.Once
+	}
+
+	shard struct {
+		mu     sync.Mutex
+		buf    []byte
+		recs   []shardRec
+		spare  []byte
+		srecs  []shardRec
+		closed bool
+		// pad keeps shards on separate cache lines
+		_ [64]byte
+	}
+
+	shardRec struct {
+		seq      uint64
+		buf      []byte // slice of the shard buffer taken by the flusher
+		from, to int
+	}
+)
+
+const (
+	// shardKickBytes makes a shard ask for an early flush once it holds this much
+	shardKickBytes = 256 << 10
+	// shardMaxBytes bounds a shard: writers flush synchronously beyond it
+	shardMaxBytes = 4 << 20
+)
+
+func newShardedWriter(next fileWriter, shards int, interval time.Duration) *shardedWriter {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	w := &shardedWriter{
+		next:   next,
+		shards: make([]shard, shards),
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run(interval)
+	return w
+}
+
+func (w *shardedWriter) Write(p []byte) (int, error) {
+	s := &w.shards[rand.Uint32()%uint32(len(w.shards))]
+	s.mu.Lock()
+	for len(s.buf) >= shardMaxBytes && !s.closed {
+		// the flusher is behind: write everything out before adding more
+		s.mu.Unlock()
+		w.keepErr(w.flush())
+		s.mu.Lock()
+	}
+	if s.closed {
+		s.mu.Unlock()
+		return 0, os.ErrClosed
+	}
+	from := len(s.buf)
+	s.buf = append(s.buf, p...)
+	s.recs = append(s.recs, shardRec{seq: w.seq.Add(1), from: from, to: len(s.buf)})
+	full := len(s.buf) >= shardKickBytes
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+func (w *shardedWriter) run(interval time.Duration) {
+	defer close(w.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+		case <-w.kick:
+		}
+		w.keepErr(w.flush())
+	}
+}
+
+// keepErr records the error of a flush for the next Sync or Close
+func (w *shardedWriter) keepErr(err error) {
+	if err == nil {
+		return
+	}
+	w.flushMu.Lock()
+	w.err = errors.Join(w.err, err)
+	w.flushMu.Unlock()
+}
+
+func (w *shardedWriter) takeErr() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	err := w.err
+	w.err = nil
+	return err
+}
+
+// flush moves everything buffered so far to the next writer
+func (w *shardedWriter) flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	// snapshot all shards at once, see the ordering note on shardedWriter
+	for i := range w.shards {
+		w.shards[i].mu.Lock()
+	}
+	w.recs = w.recs[:0]
+	for i := range w.shards {
+		s := &w.shards[i]
+		for _, r := range s.recs {
+			r.buf = s.buf
+			w.recs = append(w.recs, r)
+		}
+		s.buf, s.spare = s.spare[:0], s.buf
+		s.recs, s.srecs = s.srecs[:0], s.recs
+	}
+	for i := range w.shards {
+		w.shards[i].mu.Unlock()
+	}
+	if len(w.recs) == 0 {
+		return nil
+	}
+
+	slices.SortFunc(w.recs, func(a, b shardRec) int {
+		switch {
+		case a.seq < b.seq:
+			return -1
+		case a.seq > b.seq:
+			return 1
+		}
+		return 0
+	})
+	w.out, w.lens = w.out[:0], w.lens[:0]
+	for _, r := range w.recs {
+		w.out = append(w.out, r.buf[r.from:r.to]...)
+		w.lens = append(w.lens, r.to-r.from)
+	}
+	// drop references to shard buffers, writers reuse them after the next swap
+	clear(w.recs)
+
+	if ew, ok := w.next.(entryWriter); ok {
+		return ew.WriteEntries(w.out, w.lens)
+	}
+	buf := w.out
+	for _, n := range w.lens {
+		if _, err := w.next.Write(buf[:n]); err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// Sync flushes buffered entries, then syncs the next writer. It also
+// reports the errors of background flushes since the last Sync.
+func (w *shardedWriter) Sync() error {
+	err := w.flush()
+	return errors.Join(w.takeErr(), err, w.next.Sync())
+}
+
+// Close stops the flusher, writes what is left and closes the next writer.
+// Writes after Close fail with os.ErrClosed.
+func (w *shardedWriter) Close() error {
+	var closeErr error
+	w.once.Do(func() {
+		for i := range w.shards {
+			s := &w.shards[i]
+			s.mu.Lock()
+			s.closed = true
+			s.mu.Unlock()
+		}
+		close(w.stop)
+		<-w.done
+		err := w.flush()
+		closeErr = errors.Join(w.takeErr(), err, w.next.Close())
+	})
+	return closeErr
+}
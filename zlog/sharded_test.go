@@ -0,0 +1,158 @@
+package zlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memWriter is a fileWriter keeping what is written in memory
+type memWriter struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	fail   error
+	closed bool
+}
+
+func (m *memWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail != nil {
+		return 0, m.fail
+	}
+	return m.buf.Write(p)
+}
+
+func (m *memWriter) Sync() error { return nil }
+
+func (m *memWriter) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memWriter) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.buf.String()
+}
+
+func TestShardedConcurrentOrder(t *testing.T) {
+	mem := &memWriter{}
+	w := newShardedWriter(mem, 4, time.Millisecond)
+	const writers, perWriter = 8, 500
+	var wg sync.WaitGroup
+	for g := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perWriter {
+				if _, err := fmt.Fprintf(w, "%d %d\n", g, i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	next := make([]int, writers)
+	lines := strings.Split(strings.TrimSuffix(mem.String(), "\n"), "\n")
+	for _, l := range lines {
+		var g, i int
+		if _, err := fmt.Sscanf(l, "%d %d", &g, &i); err != nil {
+			t.Fatalf("line %q: %v", l, err)
+		}
+		if i != next[g] {
+			t.Fatalf("writer %d: entry %d after %d", g, i, next[g]-1)
+		}
+		next[g]++
+	}
+	if len(lines) != writers*perWriter {
+		t.Fatalf("%d lines, want %d", len(lines), writers*perWriter)
+	}
+}
+
+func TestShardedBounded(t *testing.T) {
+	mem := &memWriter{}
+	// the flusher only runs when kicked
+	w := newShardedWriter(mem, 1, time.Hour)
+	defer w.Close()
+	entry := bytes.Repeat([]byte("x"), 1023)
+	entry = append(entry, '\n')
+	for range 3 * shardMaxBytes / len(entry) {
+		if _, err := w.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+		s := &w.shards[0]
+		s.mu.Lock()
+		n := len(s.buf)
+		s.mu.Unlock()
+		if n > shardMaxBytes+len(entry) {
+			t.Fatalf("shard holds %d bytes, cap is %d", n, shardMaxBytes)
+		}
+	}
+}
+
+func TestShardedReportsFlushErrors(t *testing.T) {
+	errDisk := errors.New("disk full")
+	mem := &memWriter{fail: errDisk}
+	w := newShardedWriter(mem, 2, time.Millisecond)
+	if _, err := w.Write([]byte("lost\n")); err != nil {
+		t.Fatal(err)
+	}
+	// let the background flush fail
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.flushMu.Lock()
+		failed := w.err != nil
+		w.flushMu.Unlock()
+		if failed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background flush did not run")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := w.Sync(); !errors.Is(err, errDisk) {
+		t.Fatalf("Sync = %v, want %v", err, errDisk)
+	}
+	// reported once
+	if err := w.Sync(); err != nil {
+		t.Fatalf("second Sync = %v", err)
+	}
+
+	if _, err := w.Write([]byte("lost too\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); !errors.Is(err, errDisk) {
+		t.Fatalf("Close = %v, want %v", err, errDisk)
+	}
+}
+
+func TestShardedWriteAfterClose(t *testing.T) {
+	mem := &memWriter{}
+	w := newShardedWriter(mem, 2, time.Hour)
+	if _, err := w.Write([]byte("kept\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("late\n")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Write after Close = %v, want os.ErrClosed", err)
+	}
+	if got := mem.String(); got != "kept\n" || !mem.closed {
+		t.Fatalf("next writer got %q, closed=%v", got, mem.closed)
+	}
+}
@@ -0,0 +1,538 @@
+package zlog
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// SQLOption configures WrapDriver and WrapConnector
+	SQLOption func(*sqlCfg)
+
+	sqlCfg struct {
+		slow    time.Duration
+		logArgs bool
+		redact  func(driver.NamedValue) bool
+	}
+
+	// sqlLogger is shared by every connection of a wrapped driver
+	sqlLogger struct {
+		pair *Pair
+		cfg  sqlCfg
+	}
+
+	sqlDriver struct {
+		next driver.Driver
+		log  *sqlLogger
+	}
+
+	sqlConnector struct {
+		next   driver.Connector
+		driver *sqlDriver
+	}
+
+	// dsnConnector opens connections of drivers without driver.DriverContext
+	dsnConnector struct {
+		dsn    string
+		driver driver.Driver
+	}
+
+	sqlConn struct {
+		next driver.Conn
+		log  *sqlLogger
+		tx   string // ID of the open transaction, if any
+	}
+
+	sqlTx struct {
+		next driver.Tx
+		conn *sqlConn
+		ctx  context.Context
+	}
+
+	sqlStmt struct {
+		next  driver.Stmt
+		conn  *sqlConn
+		query string
+	}
+
+	// sqlArgs logs statement arguments
+	sqlArgs struct {
+		args   []driver.NamedValue
+		redact func(driver.NamedValue) bool
+	}
+)
+
+const sqlMessage = "sql"
+
+// SlowQuery logs statements taking longer than d to Pair.Error at warn level
+func SlowQuery(d time.Duration) SQLOption {
+	return func(c *sqlCfg) { c.slow = d }
+}
+
+// LogArgs logs statement arguments. Arguments for which redact returns true
+// are logged as "REDACTED"; redact may be nil.
+func LogArgs(redact func(arg driver.NamedValue) bool) SQLOption {
+	return func(c *sqlCfg) {
+		c.logArgs = true
+		c.redact = redact
+	}
+}
+
+// WrapDriver returns a driver logging every statement run through d to p, for
+// use with sql.Register:
+//
+//	sql.Register("postgres+zlog", zlog.WrapDriver(pair, &pq.Driver{}))
+//
+// Exec and query entries go to the access logger with the statement,
+// duration, rows_affected (exec), tx_id and the request_id of the context;
+// failed statements are also logged to Pair.Error.
+//
+// Connections and statements implement the optional driver interfaces
+// (Pinger, SessionResetter, Validator, NamedValueChecker, ColumnConverter)
+// exactly when the driver's do, so database/sql behaves as with d itself.
+// Drivers with only the legacy Execer and Queryer are logged too.
+func WrapDriver(p *Pair, d driver.Driver, opts ...SQLOption) driver.Driver {
+	l := &sqlLogger{pair: p}
+	for _, o := range opts {
+		o(&l.cfg)
+	}
+	return &sqlDriver{next: d, log: l}
+}
+
+// WrapConnector is WrapDriver for sql.OpenDB
+func WrapConnector(p *Pair, c driver.Connector, opts ...SQLOption) driver.Connector {
+	d := WrapDriver(p, c.Driver(), opts...).(*sqlDriver)
+	return &sqlConnector{next: c, driver: d}
+}
+
+func (d *sqlDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.next.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return wrapConn(&sqlConn{next: c, log: d.log}), nil
+}
+
+// OpenConnector implements driver.DriverContext
+func (d *sqlDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.next.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlConnector{next: c, driver: d}, nil
+	}
+	return &sqlConnector{next: dsnConnector{dsn: name, driver: d.next}, driver: d}, nil
+}
+
+func (c *sqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.next.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return wrapConn(&sqlConn{next: conn, log: c.driver.log}), nil
+}
+
+func (c *sqlConnector) Driver() driver.Driver { return c.driver }
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.driver.Open(c.dsn) }
+
+func (c dsnConnector) Driver() driver.Driver { return c.driver }
+
+// log writes the entry of one statement. op is exec, query, begin, commit or
+// rollback.
+func (l *sqlLogger) log(ctx context.Context, op, query string, args []driver.NamedValue, tx string, start time.Time, res driver.Result, err error) {
+	if errors.Is(err, driver.ErrSkip) {
+		// database/sql retries another way, which is logged then
+		return
+	}
+	d := time.Since(start)
+	fields := make([]zap.Field, 0, 8)
+	fields = append(fields, zap.String("op", op))
+	if query != "" {
+		fields = append(fields, zap.String("statement", query))
+	}
+	if l.cfg.logArgs && len(args) > 0 {
+		fields = append(fields, zap.Array("args", sqlArgs{args, l.cfg.redact}))
+	}
+	fields = append(fields, zap.Duration("duration", d))
+	if res != nil {
+		if n, rerr := res.RowsAffected(); rerr == nil {
+			fields = append(fields, zap.Int64("rows_affected", n))
+		}
+	}
+	if tx != "" {
+		fields = append(fields, zap.String("tx_id", tx))
+	}
+	if ctx != nil {
+		if id := RequestID(ctx); id != "" {
+			fields = append(fields, zap.String("request_id", id))
+		}
+	}
+
+	level := zapcore.InfoLevel
+	if err != nil {
+		level = zapcore.WarnLevel
+		fields = append(fields, zap.Error(err))
+	}
+	if ce := l.pair.Access.Check(level, sqlMessage); ce != nil {
+		ce.Write(fields...)
+	}
+	switch {
+	case err != nil:
+		l.pair.Error.Error("sql statement failed", fields...)
+	case l.cfg.slow > 0 && d >= l.cfg.slow:
+		l.pair.Error.Warn("slow sql statement", fields...)
+	}
+}
+
+func (a sqlArgs) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, arg := range a.args {
+		if a.redact != nil && a.redact(arg) {
+			enc.AppendString(redactedValue)
+			continue
+		}
+		if err := enc.AppendReflected(arg.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext implements driver.ConnPrepareContext
+func (c *sqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		s   driver.Stmt
+		err error
+	)
+	if pc, ok := c.next.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, query)
+	} else {
+		s, err = c.next.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return wrapStmt(&sqlStmt{next: s, conn: c, query: query}), nil
+}
+
+func (c *sqlConn) Close() error { return c.next.Close() }
+
+func (c *sqlConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements driver.ConnBeginTx
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	var (
+		tx  driver.Tx
+		err error
+	)
+	if bc, ok := c.next.(driver.ConnBeginTx); ok {
+		tx, err = bc.BeginTx(ctx, opts)
+	} else if opts.Isolation != 0 || opts.ReadOnly {
+		err = errors.New("zlog: driver does not support transaction options")
+	} else {
+		tx, err = c.next.Begin()
+	}
+	id := randomID()
+	c.log.log(ctx, "begin", "", nil, id, start, nil, err)
+	if err != nil {
+		return nil, err
+	}
+	c.tx = id
+	return &sqlTx{next: tx, conn: c, ctx: ctx}, nil
+}
+
+// ExecContext implements driver.ExecerContext, falling back to the legacy
+// driver.Execer. Without either database/sql prepares the statement.
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	var run func() (driver.Result, error)
+	switch e := c.next.(type) {
+	case driver.ExecerContext:
+		run = func() (driver.Result, error) { return e.ExecContext(ctx, query, args) }
+	case driver.Execer:
+		vs, err := plainValues(args)
+		if err != nil {
+			return nil, err
+		}
+		run = func() (driver.Result, error) { return e.Exec(query, vs) }
+	default:
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := run()
+	c.log.log(ctx, "exec", query, args, c.tx, start, res, err)
+	return res, err
+}
+
+// QueryContext implements driver.QueryerContext, falling back to the legacy
+// driver.Queryer. Without either database/sql prepares the statement.
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	var run func() (driver.Rows, error)
+	switch q := c.next.(type) {
+	case driver.QueryerContext:
+		run = func() (driver.Rows, error) { return q.QueryContext(ctx, query, args) }
+	case driver.Queryer:
+		vs, err := plainValues(args)
+		if err != nil {
+			return nil, err
+		}
+		run = func() (driver.Rows, error) { return q.Query(query, vs) }
+	default:
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := run()
+	c.log.log(ctx, "query", query, args, c.tx, start, nil, err)
+	return rows, err
+}
+
+// withConnInterfaces returns c with the optional interfaces given, the ones
+// database/sql detects with type assertions on a connection. Passing a nil
+// interface leaves it out.
+func withConnInterfaces(c *sqlConn, p driver.Pinger, r driver.SessionResetter, v driver.Validator, n driver.NamedValueChecker) driver.Conn {
+	// bit i is set for the i-th interface, in parameter order
+	mask := 0
+	for i, ok := range []bool{p != nil, r != nil, v != nil, n != nil} {
+		if ok {
+			mask |= 1 << i
+		}
+	}
+	switch mask {
+	case 0:
+		return c
+	case 1:
+		return struct {
+			*sqlConn
+			driver.Pinger
+		}{c, p}
+	case 2:
+		return struct {
+			*sqlConn
+			driver.SessionResetter
+		}{c, r}
+	case 3:
+		return struct {
+			*sqlConn
+			driver.Pinger
+			driver.SessionResetter
+		}{c, p, r}
+	case 4:
+		return struct {
+			*sqlConn
+			driver.Validator
+		}{c, v}
+	case 5:
+		return struct {
+			*sqlConn
+			driver.Pinger
+			driver.Validator
+		}{c, p, v}
+	case 6:
+		return struct {
+			*sqlConn
+			driver.SessionResetter
+			driver.Validator
+		}{c, r, v}
+	case 7:
+		return struct {
+			*sqlConn
+			driver.Pinger
+			driver.SessionResetter
+			driver.Validator
+		}{c, p, r, v}
+	case 8:
+		return struct {
+			*sqlConn
+			driver.NamedValueChecker
+		}{c, n}
+	case 9:
+		return struct {
+			*sqlConn
+			driver.Pinger
+			driver.NamedValueChecker
+		}{c, p, n}
+	case 10:
+		return struct {
+			*sqlConn
+			driver.SessionResetter
+			driver.NamedValueChecker
+		}{c, r, n}
+	case 11:
+		return struct {
+			*sqlConn
+			driver.Pinger
+			driver.SessionResetter
+			driver.NamedValueChecker
+		}{c, p, r, n}
+	case 12:
+		return struct {
+			*sqlConn
+			driver.Validator
+			driver.NamedValueChecker
+		}{c, v, n}
+	case 13:
+		return struct {
+			*sqlConn
+			driver.Pinger
+			driver.Validator
+			driver.NamedValueChecker
+		}{c, p, v, n}
+	case 14:
+		return struct {
+			*sqlConn
+			driver.SessionResetter
+			driver.Validator
+			driver.NamedValueChecker
+		}{c, r, v, n}
+	default: // 15
+		return struct {
+			*sqlConn
+			driver.Pinger
+			driver.SessionResetter
+			driver.Validator
+			driver.NamedValueChecker
+		}{c, p, r, v, n}
+	}
+}
+
+// wrapConn returns c with the optional interfaces of the connection it wraps
+func wrapConn(c *sqlConn) driver.Conn {
+	p, _ := c.next.(driver.Pinger)
+	r, _ := c.next.(driver.SessionResetter)
+	v, _ := c.next.(driver.Validator)
+	n, _ := c.next.(driver.NamedValueChecker)
+	return withConnInterfaces(c, p, r, v, n)
+}
+
+// columnConverter is embedded under another name: an embedded
+// driver.ColumnConverter would be a field hiding its own method
+type columnConverter = driver.ColumnConverter
+
+// withStmtInterfaces returns s with the optional interfaces given; see
+// withConnInterfaces
+func withStmtInterfaces(s *sqlStmt, cc driver.ColumnConverter, n driver.NamedValueChecker) driver.Stmt {
+	switch {
+	case cc != nil && n != nil:
+		return struct {
+			*sqlStmt
+			columnConverter
+			driver.NamedValueChecker
+		}{s, cc, n}
+	case cc != nil:
+		return struct {
+			*sqlStmt
+			columnConverter
+		}{s, cc}
+	case n != nil:
+		return struct {
+			*sqlStmt
+			driver.NamedValueChecker
+		}{s, n}
+	}
+	return s
+}
+
+// wrapStmt returns s with the optional interfaces of the statement it wraps.
+// Without a NamedValueChecker of its own, database/sql uses the connection's.
+func wrapStmt(s *sqlStmt) driver.Stmt {
+	cc, _ := s.next.(driver.ColumnConverter)
+	n, _ := s.next.(driver.NamedValueChecker)
+	return withStmtInterfaces(s, cc, n)
+}
+
+func (t *sqlTx) Commit() error {
+	return t.end("commit", t.next.Commit)
+}
+
+func (t *sqlTx) Rollback() error {
+	return t.end("rollback", t.next.Rollback)
+}
+
+func (t *sqlTx) end(op string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	t.conn.log.log(t.ctx, op, "", nil, t.conn.tx, start, nil, err)
+	t.conn.tx = ""
+	return err
+}
+
+func (s *sqlStmt) Close() error { return s.next.Close() }
+
+func (s *sqlStmt) NumInput() int { return s.next.NumInput() }
+
+func (s *sqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+// ExecContext implements driver.StmtExecContext
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var (
+		res driver.Result
+		err error
+	)
+	if ec, ok := s.next.(driver.StmtExecContext); ok {
+		res, err = ec.ExecContext(ctx, args)
+	} else if vs, verr := plainValues(args); verr != nil {
+		err = verr
+	} else {
+		res, err = s.next.Exec(vs)
+	}
+	s.conn.log.log(ctx, "exec", s.query, args, s.conn.tx, start, res, err)
+	return res, err
+}
+
+// QueryContext implements driver.StmtQueryContext
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var (
+		rows driver.Rows
+		err  error
+	)
+	if qc, ok := s.next.(driver.StmtQueryContext); ok {
+		rows, err = qc.QueryContext(ctx, args)
+	} else if vs, verr := plainValues(args); verr != nil {
+		err = verr
+	} else {
+		rows, err = s.next.Query(vs)
+	}
+	s.conn.log.log(ctx, "query", s.query, args, s.conn.tx, start, nil, err)
+	return rows, err
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	nvs := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nvs
+}
+
+// plainValues drops ordinals, as database/sql does for drivers without
+// context support; such drivers cannot take named arguments
+func plainValues(args []driver.NamedValue) ([]driver.Value, error) {
+	vs := make([]driver.Value, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, errors.New("zlog: driver does not support named arguments")
+		}
+		vs[i] = a.Value
+	}
+	return vs, nil
+}
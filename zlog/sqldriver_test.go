@@ -0,0 +1,349 @@
+package zlog
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// An in-memory driver: statements containing "fail" fail, exec reports one
+// row affected and queries return one row with n = 1
+type (
+	fakeConnector struct {
+		mode string // "ctx": ExecerContext and QueryerContext, "legacy": Execer and Queryer, "": neither
+	}
+
+	fakeDriver struct{ fakeConnector }
+
+	fakeConn struct{}
+
+	fakeCtxConn struct{ *fakeConn }
+
+	fakeLegacyConn struct{ *fakeConn }
+
+	fakeStmt struct{ query string }
+
+	fakeTx struct{}
+
+	fakeRows struct{ done bool }
+
+	// fakeOptional implements every optional interface, counting calls
+	fakeOptional struct{ calls atomic.Int32 }
+)
+
+var errFake = errors.New("fake failure")
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	switch c.mode {
+	case "ctx":
+		return fakeCtxConn{&fakeConn{}}, nil
+	case "legacy":
+		return fakeLegacyConn{&fakeConn{}}, nil
+	}
+	return &fakeConn{}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver { return fakeDriver{c} }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return d.Connect(context.Background()) }
+
+func fakeExec(query string) (driver.Result, error) {
+	if strings.Contains(query, "fail") {
+		return nil, errFake
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func fakeQuery(query string) (driver.Rows, error) {
+	if strings.Contains(query, "fail") {
+		return nil, errFake
+	}
+	return &fakeRows{}, nil
+}
+
+func (*fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{query}, nil }
+func (*fakeConn) Close() error                              { return nil }
+func (*fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (fakeCtxConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	return fakeExec(query)
+}
+
+func (fakeCtxConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	return fakeQuery(query)
+}
+
+func (fakeLegacyConn) Exec(query string, _ []driver.Value) (driver.Result, error) {
+	return fakeExec(query)
+}
+
+func (fakeLegacyConn) Query(query string, _ []driver.Value) (driver.Rows, error) {
+	return fakeQuery(query)
+}
+
+func (fakeStmt) Close() error                                 { return nil }
+func (fakeStmt) NumInput() int                                { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) { return fakeExec(s.query) }
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return fakeQuery(s.query) }
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func (*fakeRows) Columns() []string { return []string{"n"} }
+func (*fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
+
+func (o *fakeOptional) Ping(context.Context) error         { o.calls.Add(1); return nil }
+func (o *fakeOptional) ResetSession(context.Context) error { o.calls.Add(1); return nil }
+func (o *fakeOptional) IsValid() bool                      { o.calls.Add(1); return true }
+func (o *fakeOptional) CheckNamedValue(*driver.NamedValue) error {
+	o.calls.Add(1)
+	return nil
+}
+func (o *fakeOptional) ColumnConverter(int) driver.ValueConverter {
+	o.calls.Add(1)
+	return driver.DefaultParameterConverter
+}
+
+// The wrapper of a connection implements the optional interfaces of the
+// connection, and only those
+func TestSQLConnInterfaces(t *testing.T) {
+	p, _ := Test(t)
+	l := &sqlLogger{pair: p}
+	for mask := range 16 {
+		o := &fakeOptional{}
+		pick := func(bit int) *fakeOptional {
+			if mask&(1<<bit) != 0 {
+				return o
+			}
+			return nil
+		}
+		var (
+			pinger    driver.Pinger
+			resetter  driver.SessionResetter
+			validator driver.Validator
+			checker   driver.NamedValueChecker
+		)
+		if f := pick(0); f != nil {
+			pinger = f
+		}
+		if f := pick(1); f != nil {
+			resetter = f
+		}
+		if f := pick(2); f != nil {
+			validator = f
+		}
+		if f := pick(3); f != nil {
+			checker = f
+		}
+		inner := withConnInterfaces(&sqlConn{next: &fakeConn{}, log: l}, pinger, resetter, validator, checker)
+		conn := wrapConn(&sqlConn{next: inner, log: l})
+
+		calls := int32(0)
+		if c, ok := conn.(driver.Pinger); ok != (pinger != nil) {
+			t.Errorf("mask %04b: Pinger %v", mask, ok)
+		} else if ok {
+			c.Ping(context.Background())
+			calls++
+		}
+		if c, ok := conn.(driver.SessionResetter); ok != (resetter != nil) {
+			t.Errorf("mask %04b: SessionResetter %v", mask, ok)
+		} else if ok {
+			c.ResetSession(context.Background())
+			calls++
+		}
+		if c, ok := conn.(driver.Validator); ok != (validator != nil) {
+			t.Errorf("mask %04b: Validator %v", mask, ok)
+		} else if ok {
+			c.IsValid()
+			calls++
+		}
+		if c, ok := conn.(driver.NamedValueChecker); ok != (checker != nil) {
+			t.Errorf("mask %04b: NamedValueChecker %v", mask, ok)
+		} else if ok {
+			c.CheckNamedValue(&driver.NamedValue{})
+			calls++
+		}
+		if o.calls.Load() != calls {
+			t.Errorf("mask %04b: %d calls reached the connection, want %d", mask, o.calls.Load(), calls)
+		}
+		for _, ok := range []bool{
+			isType[driver.ConnPrepareContext](conn), isType[driver.ConnBeginTx](conn),
+			isType[driver.ExecerContext](conn), isType[driver.QueryerContext](conn),
+		} {
+			if !ok {
+				t.Errorf("mask %04b: a context interface is missing", mask)
+			}
+		}
+	}
+}
+
+func TestSQLStmtInterfaces(t *testing.T) {
+	p, _ := Test(t)
+	conn := &sqlConn{next: &fakeConn{}, log: &sqlLogger{pair: p}}
+	for _, tc := range []struct{ converter, checker bool }{{false, false}, {true, false}, {false, true}, {true, true}} {
+		o := &fakeOptional{}
+		var (
+			cc driver.ColumnConverter
+			nc driver.NamedValueChecker
+		)
+		if tc.converter {
+			cc = o
+		}
+		if tc.checker {
+			nc = o
+		}
+		inner := withStmtInterfaces(&sqlStmt{next: fakeStmt{}, conn: conn}, cc, nc)
+		stmt := wrapStmt(&sqlStmt{next: inner, conn: conn})
+		if c, ok := stmt.(driver.ColumnConverter); ok != tc.converter {
+			t.Errorf("%+v: ColumnConverter %v", tc, ok)
+		} else if ok {
+			c.ColumnConverter(0)
+		}
+		if c, ok := stmt.(driver.NamedValueChecker); ok != tc.checker {
+			t.Errorf("%+v: NamedValueChecker %v", tc, ok)
+		} else if ok {
+			c.CheckNamedValue(&driver.NamedValue{})
+		}
+		if want := int32(btoi(tc.converter) + btoi(tc.checker)); o.calls.Load() != want {
+			t.Errorf("%+v: %d calls reached the statement", tc, o.calls.Load())
+		}
+	}
+}
+
+func isType[T any](v any) bool {
+	_, ok := v.(T)
+	return ok
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestSQLLogging(t *testing.T) {
+	for _, mode := range []string{"ctx", "legacy", ""} {
+		t.Run("mode="+mode, func(t *testing.T) {
+			p, rec := Test(t)
+			db := sql.OpenDB(WrapConnector(p, fakeConnector{mode: mode}))
+			defer db.Close()
+			ctx := WithRequestID(context.Background(), "req-1")
+
+			if _, err := db.ExecContext(ctx, "UPDATE t SET a = 1"); err != nil {
+				t.Fatal(err)
+			}
+			var n int
+			if err := db.QueryRowContext(ctx, "SELECT n FROM t").Scan(&n); err != nil || n != 1 {
+				t.Fatalf("Scan = %d, %v", n, err)
+			}
+			if _, err := db.ExecContext(ctx, "fail"); !errors.Is(err, errFake) {
+				t.Fatalf("Exec = %v", err)
+			}
+
+			a := rec.Access()
+			if len(a) != 3 {
+				t.Fatalf("%d access entries, want one per statement: %v", len(a), a)
+			}
+			for i, want := range []struct {
+				op, statement string
+				level         string
+			}{{"exec", "UPDATE t SET a = 1", "info"}, {"query", "SELECT n FROM t", "info"}, {"exec", "fail", "warn"}} {
+				f := a[i].Fields
+				if f["op"] != want.op || f["statement"] != want.statement || a[i].Level.String() != want.level || f["request_id"] != "req-1" {
+					t.Errorf("entry %d: %s %v", i, a[i].Level, f)
+				}
+			}
+			if a[0].Fields["rows_affected"] != 1.0 {
+				t.Errorf("rows_affected = %v", a[0].Fields["rows_affected"])
+			}
+			if e := rec.Error(); len(e) != 1 || e[0].Message != "sql statement failed" {
+				t.Errorf("error entries %v", e)
+			}
+		})
+	}
+}
+
+func TestSQLTransaction(t *testing.T) {
+	p, rec := Test(t)
+	db := sql.OpenDB(WrapConnector(p, fakeConnector{mode: "ctx"}))
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO t VALUES (2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	a := rec.Access()
+	if len(a) != 4 {
+		t.Fatalf("%d entries: %v", len(a), a)
+	}
+	id := a[0].Fields["tx_id"]
+	for i, op := range []string{"begin", "exec", "commit"} {
+		if a[i].Fields["op"] != op || a[i].Fields["tx_id"] != id || id == nil {
+			t.Errorf("entry %d: %v", i, a[i].Fields)
+		}
+	}
+	if _, ok := a[3].Fields["tx_id"]; ok {
+		t.Errorf("statement after commit has a tx_id: %v", a[3].Fields)
+	}
+}
+
+func TestSQLArgsAndSlow(t *testing.T) {
+	p, rec := Test(t)
+	redact := func(a driver.NamedValue) bool { return a.Name == "password" }
+	db := sql.OpenDB(WrapConnector(p, fakeConnector{mode: "ctx"}, LogArgs(redact), SlowQuery(time.Nanosecond)))
+	defer db.Close()
+	if _, err := db.Exec("UPDATE users SET pw = @password WHERE id = @id", sql.Named("password", "s3cret"), sql.Named("id", 7)); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := rec.Access()[0].Fields["args"].([]any)
+	if len(args) != 2 || args[0] != redactedValue || args[1] != 7.0 {
+		t.Fatalf("args %v", args)
+	}
+	if e := rec.Error(); len(e) != 1 || e[0].Message != "slow sql statement" || e[0].Level.String() != "warn" {
+		t.Fatalf("error entries %v", e)
+	}
+}
+
+func TestSQLWrapDriver(t *testing.T) {
+	p, rec := Test(t)
+	sql.Register("zlog-fake-legacy", WrapDriver(p, fakeDriver{fakeConnector{mode: "legacy"}}))
+	db, err := sql.Open("zlog-fake-legacy", "dsn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("DELETE FROM t WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM t WHERE id = @id", sql.Named("id", 1)); err == nil {
+		t.Fatal("named argument accepted by a legacy driver")
+	}
+	if a := rec.Access(); len(a) != 1 || a[0].Fields["op"] != "exec" {
+		t.Fatalf("access entries %v", a)
+	}
+}
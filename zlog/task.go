@@ -0,0 +1,129 @@
+package zlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// PanicError is returned by Task when the task panicked
+	PanicError struct {
+		Value any
+		Stack []byte
+	}
+
+	// errorChain logs the errors wrapped by an error, depth first
+	errorChain struct {
+		err error
+	}
+)
+
+// task outcomes
+const (
+	outcomeSuccess  = "success"
+	outcomeFailure  = "failure"
+	outcomeCanceled = "canceled"
+	outcomePanic    = "panic"
+)
+
+// maxErrorChain bounds the errors logged in error_chain
+const maxErrorChain = 32
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Task runs fn as a named unit of work, such as a cron job or the handling
+// of a queue message. It writes "task started" and "task finished" entries to
+// the access logger, with the task name, a generated task_id, the attempt
+// (see WithAttempt), and on finish the duration and the outcome: success,
+// failure, canceled or panic.
+//
+// Failures are also logged to Pair.Error with the error_chain of wrapped
+// errors. A panic in fn is recovered, logged with its stack and returned as
+// a *PanicError. Cancellation of ctx is not a failure.
+//
+// fn gets a context carrying a Pair that adds task and task_id to every
+// entry; use FromContext to get it.
+func Task(ctx context.Context, p *Pair, name string, fn func(ctx context.Context) error) (err error) {
+	fields := []zap.Field{
+		zap.String("task", name),
+		zap.String("task_id", randomID()),
+	}
+	if id := RequestID(ctx); id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+	tp := p.With(fields...)
+	attempt := zap.Int("attempt", attemptOf(ctx))
+
+	tp.Access.Info("task started", attempt)
+	start := time.Now()
+	defer func() {
+		outcome := outcomeSuccess
+		if v := recover(); v != nil {
+			outcome = outcomePanic
+			pe := &PanicError{Value: v, Stack: debug.Stack()}
+			err = pe
+			tp.Error.Error("task panicked", attempt, zap.Any("panic", v), zap.ByteString("panic_stack", pe.Stack))
+		} else if err != nil {
+			outcome = outcomeFailure
+			if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+				outcome = outcomeCanceled
+			} else {
+				tp.Error.Error("task failed", attempt, zap.Error(err), zap.Array("error_chain", errorChain{err}))
+			}
+		}
+
+		level := zapcore.InfoLevel
+		if outcome != outcomeSuccess {
+			level = zapcore.WarnLevel
+		}
+		if ce := tp.Access.Check(level, "task finished"); ce != nil {
+			fs := []zap.Field{attempt, zap.Duration("duration", time.Since(start)), zap.String("outcome", outcome)}
+			if err != nil {
+				fs = append(fs, zap.Error(err))
+			}
+			ce.Write(fs...)
+		}
+	}()
+	return fn(WithPair(ctx, tp))
+}
+
+// MarshalLogArray logs the chain as {type, msg} objects; errors.Join and
+// other multi-errors are walked depth first
+func (c errorChain) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	n := 0
+	var walk func(error) error
+	walk = func(err error) error {
+		if err == nil || n >= maxErrorChain {
+			return nil
+		}
+		n++
+		if aerr := enc.AppendObject(zapcore.ObjectMarshalerFunc(func(o zapcore.ObjectEncoder) error {
+			o.AddString("type", reflect.TypeOf(err).String())
+			o.AddString("msg", err.Error())
+			return nil
+		})); aerr != nil {
+			return aerr
+		}
+		switch u := err.(type) {
+		case interface{ Unwrap() error }:
+			return walk(u.Unwrap())
+		case interface{ Unwrap() []error }:
+			for _, e := range u.Unwrap() {
+				if werr := walk(e); werr != nil {
+					return werr
+				}
+			}
+		}
+		return nil
+	}
+	return walk(c.err)
+}
@@ -0,0 +1,97 @@
+package zlog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTask(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		fn      func(context.Context) error
+		outcome string
+		errMsg  string
+	}{
+		{"success", func(context.Context) error { return nil }, outcomeSuccess, ""},
+		{"failure", func(context.Context) error { return errors.New("boom") }, outcomeFailure, "task failed"},
+		{"panic", func(context.Context) error { panic("boom") }, outcomePanic, "task panicked"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, rec := Test(t)
+			err := Task(WithRequestID(context.Background(), "req-1"), p, "job", func(ctx context.Context) error {
+				FromContext(ctx).Access.Info("inside")
+				return tc.fn(ctx)
+			})
+			if (err != nil) != (tc.outcome != outcomeSuccess) {
+				t.Fatalf("Task = %v", err)
+			}
+			a := rec.Access()
+			if len(a) != 3 || a[0].Message != "task started" || a[2].Message != "task finished" {
+				t.Fatalf("access entries %v", a)
+			}
+			id := a[0].Fields["task_id"]
+			for _, e := range a {
+				if e.Fields["task"] != "job" || e.Fields["task_id"] != id || e.Fields["request_id"] != "req-1" {
+					t.Errorf("entry %q: %v", e.Message, e.Fields)
+				}
+			}
+			if a[2].Fields["outcome"] != tc.outcome || a[2].Fields["attempt"] != 1.0 {
+				t.Errorf("finish entry %v", a[2].Fields)
+			}
+			e := rec.Error()
+			if tc.errMsg == "" && len(e) != 0 || tc.errMsg != "" && (len(e) != 1 || e[0].Message != tc.errMsg) {
+				t.Errorf("error entries %v", e)
+			}
+		})
+	}
+}
+
+func TestTaskCanceled(t *testing.T) {
+	p, rec := Test(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Task(ctx, p, "job", func(ctx context.Context) error { return ctx.Err() }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Task = %v", err)
+	}
+	if a := rec.Access(); a[1].Fields["outcome"] != outcomeCanceled || len(rec.Error()) != 0 {
+		t.Fatalf("access %v, error %v", a, rec.Error())
+	}
+}
+
+// The attempt of a task does not make its requests retries, nor the other
+// way around
+func TestTaskAttemptIsNotRetry(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	p, rec := Test(t)
+	client := &http.Client{Transport: RoundTripper(p, nil)}
+
+	for _, tc := range []struct {
+		ctx     context.Context
+		attempt float64
+		retries any
+	}{
+		{WithAttempt(context.Background(), 3), 3, nil},
+		{WithRetry(context.Background(), 2), 1, 2.0},
+	} {
+		rec.Reset()
+		err := Task(tc.ctx, p, "job", func(ctx context.Context) error {
+			req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			return resp.Body.Close()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := rec.Access()
+		if len(a) != 3 || a[0].Fields["attempt"] != tc.attempt || a[1].Fields["retries"] != tc.retries {
+			t.Errorf("entries %v", a)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package zlog
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// pathTemplate is a file path with {field} placeholders, e.g.
+	// /var/log/app/{tenant}/access.log
+	pathTemplate struct {
+		raw   string
+		parts []string // literal text at even indexes, field keys at odd ones
+		keys  map[string]bool
+	}
+
+	// templateOutput resolves a pathTemplate per entry and keeps a bounded
+	// pool of open rotating writers, closing the least recently used one when
+	// the pool is full
+	templateOutput struct {
+		tmpl pathTemplate
+		cfg  rotateCfg
+
+		mu      sync.Mutex
+		open    map[string]*list.Element
+		lru     *list.List               // of *pooledWriter, most recent first
+		closing map[string]*pooledWriter // evicted, not closed yet
+		// closeErrs holds the errors of closing evicted writers, returned by
+		// the next Sync or Close
+		closeErrs []error
+		closed    bool
+	}
+
+	pooledWriter struct {
+		path    string
+		w       fileWriter
+		refs    int
+		evicted bool
+		closed  chan struct{} // closed once w is closed
+	}
+)
+
+const (
+	defaultTemplateMaxOpen = 64
+	// unknownPlaceholder replaces a missing field when no fallback path is set
+	unknownPlaceholder = "unknown"
+)
+
+// placeholderRe matches a {field} placeholder; other braces are literal
+var placeholderRe = regexp.MustCompile(`\{([A-Za-z0-9_.\-]+)\}`)
+
+// isPathTemplate reports whether path contains {field} placeholders
+func isPathTemplate(path string) bool {
+	return placeholderRe.MatchString(path)
+}
+
+func parsePathTemplate(raw string) pathTemplate {
+	t := pathTemplate{raw: raw, keys: map[string]bool{}}
+	last := 0
+	for _, m := range placeholderRe.FindAllStringSubmatchIndex(raw, -1) {
+		key := raw[m[2]:m[3]]
+		t.parts = append(t.parts, raw[last:m[0]], key)
+		t.keys[key] = true
+		last = m[1]
+	}
+	t.parts = append(t.parts, raw[last:])
+	return t
+}
+
+// resolve substitutes placeholders with values found in fields, later fields
+// overriding earlier ones. ok is false when a placeholder had no usable value.
+func (t pathTemplate) resolve(ctx, fields []zapcore.Field) (path string, ok bool) {
+	values := make(map[string]string, len(t.keys))
+	for _, fs := range [][]zapcore.Field{ctx, fields} {
+		for i := range fs {
+			if t.keys[fs[i].Key] {
+				values[fs[i].Key] = fieldString(fs[i])
+			}
+		}
+	}
+
+	ok = true
+	var b strings.Builder
+	for i, p := range t.parts {
+		if i%2 == 0 {
+			b.WriteString(p)
+			continue
+		}
+		v := sanitizePathValue(values[p])
+		if v == "" {
+			ok = false
+			v = unknownPlaceholder
+		}
+		b.WriteString(v)
+	}
+	return b.String(), ok
+}
+
+// fieldString renders a field value as plain text
+func fieldString(f zapcore.Field) string {
+	switch f.Type {
+	case zapcore.StringType:
+		return f.String
+	case zapcore.Int64Type, zapcore.Int32Type, zapcore.Int16Type, zapcore.Int8Type:
+		return strconv.FormatInt(f.Integer, 10)
+	case zapcore.Uint64Type, zapcore.Uint32Type, zapcore.Uint16Type, zapcore.Uint8Type, zapcore.UintptrType:
+		return strconv.FormatUint(uint64(f.Integer), 10)
+	case zapcore.BoolType:
+		return strconv.FormatBool(f.Integer == 1)
+	case zapcore.StringerType:
+		if s, ok := f.Interface.(fmt.Stringer); ok {
+			return s.String()
+		}
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	f.AddTo(enc)
+	if v, ok := enc.Fields[f.Key]; ok {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// sanitizePathValue keeps a field value from escaping its directory: only
+// letters, digits, '-', '_' and '.' are kept, and "." / ".." are rejected
+func sanitizePathValue(v string) string {
+	if v == "." || v == ".." {
+		return ""
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, v)
+}
+
+func newTemplateOutput(c rotateCfg) (*templateOutput, error) {
+	if c.MaxOpen <= 0 {
+		c.MaxOpen = defaultTemplateMaxOpen
+	}
+	return &templateOutput{
+		tmpl:    parsePathTemplate(c.Path),
+		cfg:     c,
+		open:    map[string]*list.Element{},
+		lru:     list.New(),
+		closing: map[string]*pooledWriter{},
+	}, nil
+}
+
+func (o *templateOutput) needsFields() bool { return true }
+
+func (o *templateOutput) acquire(ctx, fields []zapcore.Field) (zapcore.WriteSyncer, func(), error) {
+	path, ok := o.tmpl.resolve(ctx, fields)
+	if !ok && o.cfg.Fallback != "" {
+		path = o.cfg.Fallback
+	}
+
+	o.mu.Lock()
+	for {
+		if o.closed {
+			o.mu.Unlock()
+			return nil, nil, os.ErrClosed
+		}
+		if el, ok := o.open[path]; ok {
+			o.lru.MoveToFront(el)
+			pw := el.Value.(*pooledWriter)
+			pw.refs++
+			o.mu.Unlock()
+			return pw.w, o.releaseFunc(pw), nil
+		}
+		// an evicted writer for the same file is still in use or closing:
+		// wait for it, so two writers never append to one file
+		old, ok := o.closing[path]
+		if !ok {
+			break
+		}
+		o.mu.Unlock()
+		<-old.closed
+		o.mu.Lock()
+	}
+
+	cfg := o.cfg
+	cfg.Path = path
+	w, err := newRotateWriter(cfg)
+	if err != nil {
+		o.mu.Unlock()
+		return nil, nil, err
+	}
+	pw := &pooledWriter{path: path, w: w, refs: 1, closed: make(chan struct{})}
+	o.open[path] = o.lru.PushFront(pw)
+	var idle []*pooledWriter
+	for o.lru.Len() > o.cfg.MaxOpen {
+		if old := o.evict(o.lru.Back()); old != nil {
+			idle = append(idle, old)
+		}
+	}
+	o.mu.Unlock()
+
+	for _, old := range idle {
+		o.closeWriter(old)
+	}
+	return w, o.releaseFunc(pw), nil
+}
+
+func (o *templateOutput) releaseFunc(pw *pooledWriter) func() {
+	return func() {
+		o.mu.Lock()
+		pw.refs--
+		closeNow := pw.evicted && pw.refs == 0
+		o.mu.Unlock()
+		if closeNow {
+			o.closeWriter(pw)
+		}
+	}
+}
+
+// evict drops el from the pool and returns its writer if no write uses it,
+// for the caller to close after releasing o.mu; otherwise the last release
+// closes it. Must be called with o.mu held.
+func (o *templateOutput) evict(el *list.Element) *pooledWriter {
+	pw := o.lru.Remove(el).(*pooledWriter)
+	delete(o.open, pw.path)
+	pw.evicted = true
+	o.closing[pw.path] = pw
+	if pw.refs == 0 {
+		return pw
+	}
+	return nil
+}
+
+// closeWriter closes an evicted writer, keeping its error for Sync or Close,
+// and lets its path be opened again
+func (o *templateOutput) closeWriter(pw *pooledWriter) {
+	err := pw.w.Close()
+	o.mu.Lock()
+	if o.closing[pw.path] == pw {
+		delete(o.closing, pw.path)
+	}
+	if err != nil {
+		o.closeErrs = append(o.closeErrs, fmt.Errorf("closing %s: %w", pw.path, err))
+	}
+	o.mu.Unlock()
+	close(pw.closed)
+}
+
+// takeCloseErrs returns and forgets the errors of closing evicted writers
+func (o *templateOutput) takeCloseErrs() []error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	errs := o.closeErrs
+	o.closeErrs = nil
+	return errs
+}
+
+func (o *templateOutput) writers() []fileWriter {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ws := make([]fileWriter, 0, o.lru.Len())
+	for el := o.lru.Front(); el != nil; el = el.Next() {
+		ws = append(ws, el.Value.(*pooledWriter).w)
+	}
+	return ws
+}
+
+func (o *templateOutput) Sync() error {
+	errs := o.takeCloseErrs()
+	for _, w := range o.writers() {
+		if err := w.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every writer, waiting for those still in use, and makes
+// later writes fail with os.ErrClosed
+func (o *templateOutput) Close() error {
+	var idle, closing []*pooledWriter
+	o.mu.Lock()
+	o.closed = true
+	for o.lru.Len() > 0 {
+		if pw := o.evict(o.lru.Back()); pw != nil {
+			idle = append(idle, pw)
+		}
+	}
+	for _, pw := range o.closing {
+		closing = append(closing, pw)
+	}
+	o.mu.Unlock()
+
+	for _, pw := range idle {
+		o.closeWriter(pw)
+	}
+	for _, pw := range closing {
+		<-pw.closed
+	}
+	return errors.Join(o.takeCloseErrs()...)
+}
@@ -0,0 +1,244 @@
+package zlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestIsPathTemplate(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/var/log/{tenant}/access.log":    true,
+		"/var/log/{svc.name}-{env}.log":   true,
+		"/var/log/app.log":                false,
+		"/var/log/{tenant/access.log":     false,
+		"/var/log/{}/access.log":          false,
+		"/var/log/{two words}/access.log": false,
+	} {
+		if got := isPathTemplate(path); got != want {
+			t.Errorf("isPathTemplate(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestPathTemplateResolve(t *testing.T) {
+	tmpl := parsePathTemplate("/logs/{a}/{b}-{c.log")
+	for _, tc := range []struct {
+		fields []zapcore.Field
+		want   string
+		ok     bool
+	}{
+		{[]zapcore.Field{zap.String("a", "x"), zap.Int("b", 7)}, "/logs/x/7-{c.log", true},
+		{[]zapcore.Field{zap.String("a", "../etc"), zap.String("b", "y")}, "/logs/.._etc/y-{c.log", true},
+		{[]zapcore.Field{zap.String("a", "..")}, "/logs/unknown/unknown-{c.log", false},
+		{nil, "/logs/unknown/unknown-{c.log", false},
+	} {
+		got, ok := tmpl.resolve(nil, tc.fields)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("resolve(%v) = %q, %v; want %q, %v", tc.fields, got, ok, tc.want, tc.ok)
+		}
+	}
+	// entry fields override context fields
+	got, _ := parsePathTemplate("{t}").resolve([]zapcore.Field{zap.String("t", "ctx")}, []zapcore.Field{zap.String("t", "entry")})
+	if got != "entry" {
+		t.Errorf("resolve = %q, want entry", got)
+	}
+}
+
+// An evicted writer still in use must be closed before its file is opened again
+func TestTemplateReopenWaitsForEvicted(t *testing.T) {
+	dir := t.TempDir()
+	o, err := newTemplateOutput(rotateCfg{Path: filepath.Join(dir, "{t}.log"), MaxOpen: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	field := func(v string) []zapcore.Field { return []zapcore.Field{zap.String("t", v)} }
+
+	_, releaseA, err := o.acquire(nil, field("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, releaseB, err := o.acquire(nil, field("b")) // evicts a, still in use
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseB()
+
+	reopened := make(chan func())
+	go func() {
+		_, release, err := o.acquire(nil, field("a"))
+		if err != nil {
+			t.Error(err)
+		}
+		reopened <- release
+	}()
+	select {
+	case <-reopened:
+		t.Fatal("a was reopened while its evicted writer was in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	releaseA()
+	select {
+	case release := <-reopened:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("a was not reopened after its evicted writer was released")
+	}
+}
+
+func TestTemplateConcurrentEviction(t *testing.T) {
+	dir := t.TempDir()
+	o, err := newTemplateOutput(rotateCfg{Path: filepath.Join(dir, "{t}.log"), MaxOpen: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const tenants, perTenant = 5, 200
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tenants * perTenant / 8 {
+				tenant := fmt.Sprint((g + i) % tenants)
+				w, release, err := o.acquire(nil, []zapcore.Field{zap.String("t", tenant)})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := w.Write([]byte(tenant + "\n")); err != nil {
+					t.Error(err)
+				}
+				release()
+			}
+		}()
+	}
+	wg.Wait()
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0
+	for i := range tenants {
+		b, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.log", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := bytes.Split(bytes.TrimSuffix(b, []byte("\n")), []byte("\n"))
+		for _, l := range lines {
+			if string(l) != fmt.Sprint(i) {
+				t.Fatalf("%d.log has line %q", i, l)
+			}
+		}
+		total += len(lines)
+	}
+	if total != tenants*perTenant {
+		t.Fatalf("%d lines written, want %d", total, tenants*perTenant)
+	}
+}
+
+// failCloseWriter is a fileWriter whose Close fails
+type failCloseWriter struct{ bytes.Buffer }
+
+func (*failCloseWriter) Sync() error  { return nil }
+func (*failCloseWriter) Close() error { return errors.New("close failed") }
+
+// pool puts a writer for path in o, as acquire would
+func (o *templateOutput) pool(path string, w fileWriter) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.open[path] = o.lru.PushFront(&pooledWriter{path: path, w: w, closed: make(chan struct{})})
+}
+
+func TestTemplateCloseErrors(t *testing.T) {
+	dir := t.TempDir()
+	o, err := newTemplateOutput(rotateCfg{Path: filepath.Join(dir, "{t}.log"), MaxOpen: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := func(v string) []zapcore.Field { return []zapcore.Field{zap.String("t", v)} }
+
+	o.pool(filepath.Join(dir, "x.log"), &failCloseWriter{})
+	_, release, err := o.acquire(nil, field("a")) // evicts x.log
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if err := o.Sync(); err == nil || !strings.Contains(err.Error(), "x.log") {
+		t.Fatalf("Sync = %v, want the error of closing x.log", err)
+	}
+	if err := o.Sync(); err != nil {
+		t.Fatalf("second Sync = %v", err)
+	}
+
+	o.pool(filepath.Join(dir, "y.log"), &failCloseWriter{})
+	if err := o.Close(); err == nil || !strings.Contains(err.Error(), "y.log") {
+		t.Fatalf("Close = %v, want the error of closing y.log", err)
+	}
+	if _, _, err := o.acquire(nil, field("b")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("acquire after Close = %v, want os.ErrClosed", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.log")); !os.IsNotExist(err) {
+		t.Fatalf("b.log opened after Close: %v", err)
+	}
+}
+
+// openLogs counts the files ending in .log under dir that the process has
+// open; ok is false where this cannot be told
+func openLogs(dir string) (n int, ok bool) {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, false
+	}
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err == nil && strings.HasPrefix(target, dir) && strings.HasSuffix(target, ".log") {
+			n++
+		}
+	}
+	return n, true
+}
+
+func TestTemplateOptions(t *testing.T) {
+	dir := t.TempDir()
+	fallback := filepath.Join(dir, "other.log")
+	p, err := New(WithAccessFile(filepath.Join(dir, "{tenant}", "access.log"), 1, 0, 0, false,
+		TemplateMaxOpen(2), TemplateFallback(fallback)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tenant := range []string{"a", "b", "a", "c", "a"} {
+		p.Access.Info("request", zap.String("tenant", tenant))
+		if n, ok := openLogs(dir); ok && n > 2 {
+			t.Fatalf("%d files open, want at most 2", n)
+		}
+	}
+	p.Access.Info("no tenant")
+	p.Access.Info("bad tenant", zap.String("tenant", ".."))
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]int{
+		filepath.Join(dir, "a", "access.log"): 3,
+		filepath.Join(dir, "b", "access.log"): 1,
+		filepath.Join(dir, "c", "access.log"): 1,
+		fallback:                              2,
+	} {
+		if n := len(readLines(t, path)); n != want {
+			t.Errorf("%s: %d entries, want %d", path, n, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, unknownPlaceholder)); !os.IsNotExist(err) {
+		t.Errorf("placeholder directory created with a fallback set: %v", err)
+	}
+}
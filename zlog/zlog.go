@@ -1,12 +1,14 @@
 package zlog
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type (
@@ -19,6 +21,18 @@ type (
 		// AccessLevel and ErrorLevel are public and can be changed at runtime
 		AccessLevel zap.AtomicLevel
 		ErrorLevel  zap.AtomicLevel
+
+		// SinkLevels holds the level of every sink by name ("access:/var/log/app/access.log",
+		// "error:stderr", ...). A sink writes an entry only if both its level and
+		// the level of its logger allow it.
+		SinkLevels map[string]zap.AtomicLevel
+
+		// Filters drops or keeps entries of both loggers by rule; its rules
+		// can be replaced at runtime
+		Filters *FilterSet
+
+		closers []io.Closer
+		exit    *exitSyncer
 	}
 
 	rotateCfg struct {
@@ -27,20 +41,113 @@ type (
 		MaxBackups int
 		MaxAgeDays int
 		Compress   bool
+		Fsync      syncPolicy
+		Manifest   bool
+		LocalTime  bool
+		// TimeOf reads entry times back, for the manifest
+		TimeOf func([]byte) (time.Time, bool)
+
+		// Sharded buffers entries per shard and writes them from one goroutine
+		Sharded    bool
+		Shards     int
+		ShardFlush time.Duration
+
+		// Trusted sinks get the real value of Sensitive fields
+		Trusted bool
+
+		// Name and Level identify the sink in Pair.SinkLevels
+		Name  string
+		Level zapcore.Level
+
+		// Retention compacts aged backups; LevelOf reads entry levels for it
+		Retention []RetentionTier
+		LevelOf   func([]byte) (zapcore.Level, bool)
+
+		// Processors run for this sink only, after the logger's
+		Processors processorChain
+
+		// Console mirrors the file's entries, at the same levels, to a writer
+		Console io.Writer
+
+		// path templates only
+		MaxOpen  int
+		Fallback string
+	}
+
+	// fileWriter is a WriteSyncer that owns an open file
+	fileWriter interface {
+		zapcore.WriteSyncer
+		io.Closer
+	}
+
+	nopCloseWriter struct {
+		zapcore.WriteSyncer
+	}
+
+	// levelRoute sends entries within [min, max] to its own file
+	levelRoute struct {
+		min, max zapcore.Level
+		file     rotateCfg
+	}
+
+	// consoleRoute sends entries within [min, max] to a console writer
+	consoleRoute struct {
+		w        io.Writer
+		min, max zapcore.Level
+	}
+
+	// coreBuilder collects the sinks of one logger
+	coreBuilder struct {
+		name    string // logger name, prefixes sink names
+		fileEnc func() zapcore.Encoder
+		consEnc func() zapcore.Encoder
+		level   zap.AtomicLevel
+		hash    sensitiveRenderer                  // untrusted rendering of Sensitive fields, nil to mask
+		levelOf func([]byte) (zapcore.Level, bool) // reads levels back for Retention
+		timeOf  func([]byte) (time.Time, bool)     // reads times back for the manifest
+		cores   []zapcore.Core
+		closers []io.Closer
+		levels  map[string]zap.AtomicLevel // sink levels by unique name, may be shared
+	}
+
+	samplingCfg struct {
+		tick              time.Duration
+		first, thereafter int
 	}
 
 	buildCfg struct {
-		access rotateCfg
-		error  rotateCfg
+		access      rotateCfg
+		error       rotateCfg
+		errorRoutes []levelRoute
 
-		consoleStdout bool
-		consoleStderr bool
+		consoleStdout  bool
+		consoleStderr  bool
+		accessConsoles []consoleRoute
+		errorConsoles  []consoleRoute
 
-		enc     zapcore.EncoderConfig
-		zapOpts []zap.Option
+		enc             zapcore.EncoderConfig
+		fileEncoding    string
+		consoleEncoding string
+		color           bool
+		zapOpts         []zap.Option
+
+		sampling      *samplingCfg
+		sensitiveHash sensitiveRenderer
+		ipAnonymizer  *IPAnonymizer
+		recorder      *Recorder
+		crashOutput   bool
+		stamper       *entryStamper
+		seqKey        string
+		entryIDKey    string
+		accessProcs   processorChain
+		errorProcs    processorChain
+		filters       []FilterRule
 
 		initialAccessLevel zapcore.Level
 		initialErrorLevel  zapcore.Level
+
+		// errs collects invalid options, New returns them
+		errs []error
 	}
 )
 
@@ -64,6 +171,39 @@ func (p *Pair) Sync() error {
 	return nil
 }
 
+// With returns a Pair whose loggers add fields to every entry. It shares the
+// sinks and levels of p; closing it only syncs, close p instead.
+func (p *Pair) With(fields ...zap.Field) *Pair {
+	cp := *p
+	cp.Access = p.Access.With(fields...)
+	cp.Error = p.Error.With(fields...)
+	cp.closers, cp.exit = nil, nil
+	return &cp
+}
+
+// Close syncs and closes the log files and stops background goroutines started
+// for them. The loggers must not be used after Close.
+func (p *Pair) Close() error {
+	if p.exit != nil {
+		exits.remove(p.exit.id)
+		p.exit = nil
+	}
+	var errs []error
+	if err := p.Sync(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, c := range p.closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	p.closers = nil
+	if len(errs) > 0 {
+		return &syncError{errs: errs}
+	}
+	return nil
+}
+
 type syncError struct {
 	errs []error
 }
@@ -79,6 +219,9 @@ func (e *syncError) Unwrap() []error {
 	return e.errs
 }
 
+// warnOutput receives configuration warnings from New
+var warnOutput io.Writer = os.Stderr
+
 func defaultEncoder() zapcore.EncoderConfig {
 	return zapcore.EncoderConfig{
 		TimeKey:        "ts",
@@ -92,33 +235,133 @@ func defaultEncoder() zapcore.EncoderConfig {
 	}
 }
 
-func newRotateWriter(c rotateCfg) zapcore.WriteSyncer {
+func (nopCloseWriter) Close() error { return nil }
+
+// newEncoder builds a JSON or console encoder; color only affects console encoding
+func newEncoder(encoding string, cfg zapcore.EncoderConfig, color bool) zapcore.Encoder {
+	if encoding != EncodingConsole {
+		return zapcore.NewJSONEncoder(cfg)
+	}
+	if color {
+		cfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+	return zapcore.NewConsoleEncoder(cfg)
+}
+
+func newRotateWriter(c rotateCfg) (fileWriter, error) {
+	w := fileWriter(newDurableWriter(newFileRotator(c), c.Fsync))
+	if c.Sharded {
+		w = newShardedWriter(w, c.Shards, c.ShardFlush)
+	}
+	return w, nil
+}
+
+// newOutput opens the destination of a file sink: a single rotating file, or
+// a pool of them when the path is a template
+func newOutput(c rotateCfg) (sinkOutput, error) {
+	if isPathTemplate(c.Path) {
+		return newTemplateOutput(c)
+	}
+	w, err := newRotateWriter(c)
+	if err != nil {
+		return nil, err
+	}
+	return staticOutput{w}, nil
+}
+
+func consoleOutput(w io.Writer) sinkOutput {
+	return staticOutput{nopCloseWriter{zapcore.AddSync(w)}}
+}
+
+// addFile adds a file sink, and its console mirror if any, enabled for lvl
+// (nil meaning every level the logger accepts)
+func (b *coreBuilder) addFile(c rotateCfg, lvl zapcore.LevelEnabler) error {
 	if c.Path == "" {
 		// Empty path means discard logs
-		return zapcore.AddSync(io.Discard)
+		return nil
+	}
+	if len(c.Retention) > 0 {
+		c.LevelOf = b.levelOf
+	}
+	c.TimeOf = b.timeOf
+	out, err := newOutput(c)
+	if err != nil {
+		return err
+	}
+	b.closers = append(b.closers, out)
+	name := c.Name
+	if name == "" {
+		name = b.name + ":" + c.Path
 	}
-	// lumberjack MaxSize is in megabytes
-	return zapcore.AddSync(&lumberjack.Logger{
-		Filename:   c.Path,
-		MaxSize:    c.MaxSizeMB,
-		MaxBackups: c.MaxBackups,
-		MaxAge:     c.MaxAgeDays,
-		Compress:   c.Compress,
-	})
+	sensitive := b.hash
+	if c.Trusted {
+		sensitive = revealSensitive
+	}
+	name, file := b.sink(name, b.fileEnc(), out, lvl, c.Level, sensitive)
+	group := []*sinkCore{file}
+	if c.Console != nil {
+		_, mirror := b.sink(name+":mirror", b.consEnc(), consoleOutput(c.Console), lvl, c.Level, b.hash)
+		group = append(group, mirror)
+	}
+	if len(c.Processors) > 0 {
+		b.cores = append(b.cores, &sinkGroup{sinks: group, procs: c.Processors})
+		return nil
+	}
+	for _, s := range group {
+		b.cores = append(b.cores, s)
+	}
+	return nil
 }
 
-func makeCore(encCfg zapcore.EncoderConfig, ws zapcore.WriteSyncer, lvl zap.AtomicLevel) zapcore.Core {
-	return zapcore.NewCore(zapcore.NewJSONEncoder(encCfg), ws, lvl)
+// addConsole adds a console sink writing to w
+func (b *coreBuilder) addConsole(w io.Writer, lvl zapcore.LevelEnabler) {
+	name := "console"
+	switch w {
+	case os.Stdout:
+		name = "stdout"
+	case os.Stderr:
+		name = "stderr"
+	}
+	b.add(b.name+":"+name, b.consEnc(), consoleOutput(w), lvl, zapcore.DebugLevel, b.hash)
 }
 
-func tee(ws1, ws2 zapcore.WriteSyncer) zapcore.WriteSyncer {
-	switch {
-	case ws1 == nil:
-		return ws2
-	case ws2 == nil:
-		return ws1
-	default:
-		return zapcore.NewMultiWriteSyncer(ws1, ws2)
+// add registers a sink with its own runtime level and returns its unique name
+func (b *coreBuilder) add(name string, enc zapcore.Encoder, out sinkOutput, lvl zapcore.LevelEnabler, initial zapcore.Level, sensitive sensitiveRenderer) string {
+	name, core := b.sink(name, enc, out, lvl, initial, sensitive)
+	b.cores = append(b.cores, core)
+	return name
+}
+
+// sink builds a sink with its own runtime level, leaving it to the caller to
+// add, and returns its unique name
+func (b *coreBuilder) sink(name string, enc zapcore.Encoder, out sinkOutput, lvl zapcore.LevelEnabler, initial zapcore.Level, sensitive sensitiveRenderer) (string, *sinkCore) {
+	if b.levels == nil {
+		b.levels = map[string]zap.AtomicLevel{}
+	}
+	unique := name
+	for i := 2; ; i++ {
+		if _, taken := b.levels[unique]; !taken {
+			break
+		}
+		unique = fmt.Sprintf("%s#%d", name, i)
+	}
+	sinkLevel := zap.NewAtomicLevelAt(initial)
+	b.levels[unique] = sinkLevel
+
+	enabler := andLevels(b.level, lvl, sinkLevel)
+	return unique, newSinkCore(enc, out, enabler, sensitive)
+}
+
+// core tees all sinks
+func (b *coreBuilder) core() *loggerCore {
+	c := newLoggerCore(b.cores)
+	c.name = b.name
+	return c
+}
+
+func closeAll(cs []io.Closer) {
+	for _, c := range cs {
+		_ = c.Close()
 	}
 }
 
@@ -130,47 +373,140 @@ func New(opts ...Option) (*Pair, error) {
 		consoleStdout:      false,
 		consoleStderr:      false,
 		enc:                defaultEncoder(),
+		fileEncoding:       EncodingJSON,
+		consoleEncoding:    EncodingJSON,
 		initialAccessLevel: zapcore.InfoLevel,
 		initialErrorLevel:  zapcore.ErrorLevel,
 		zapOpts:            []zap.Option{},
+		seqKey:             defaultSeqKey,
+		entryIDKey:         defaultEntryIDKey,
 	}
 	for _, o := range opts {
 		o(&cfg)
 	}
+	if err := errors.Join(cfg.errs...); err != nil {
+		return nil, err
+	}
 
 	// levels
 	accessLevel := zap.NewAtomicLevelAt(cfg.initialAccessLevel)
 	errorLevel := zap.NewAtomicLevelAt(cfg.initialErrorLevel)
 
-	// writers
-	accessFile := newRotateWriter(cfg.access)
-	errorFile := newRotateWriter(cfg.error)
+	// cores (tee: file + console)
+	fileEnc := func() zapcore.Encoder { return newEncoder(cfg.fileEncoding, cfg.enc, false) }
+	consEnc := func() zapcore.Encoder { return newEncoder(cfg.consoleEncoding, cfg.enc, cfg.color) }
+	levelOf := entryLevelFunc(cfg.fileEncoding, cfg.enc.LevelKey)
+	timeOf := entryTimeFunc(cfg.fileEncoding, cfg.enc.TimeKey)
+	accessB := &coreBuilder{name: "access", fileEnc: fileEnc, consEnc: consEnc, level: accessLevel, hash: cfg.sensitiveHash, levelOf: levelOf, timeOf: timeOf}
+	errorB := &coreBuilder{name: "error", fileEnc: fileEnc, consEnc: consEnc, level: errorLevel, hash: cfg.sensitiveHash, levelOf: levelOf, timeOf: timeOf}
+	// one namespace for the sinks of both loggers, so names stay unique
+	sinkLevels := map[string]zap.AtomicLevel{}
+	accessB.levels, errorB.levels = sinkLevels, sinkLevels
+	fail := func(err error) (*Pair, error) {
+		closeAll(accessB.closers)
+		closeAll(errorB.closers)
+		return nil, err
+	}
+
+	if err := accessB.addFile(cfg.access, nil); err != nil {
+		return fail(err)
+	}
+	if err := errorB.addFile(cfg.error, nil); err != nil {
+		return fail(err)
+	}
+	for _, r := range cfg.errorRoutes {
+		if r.max < cfg.initialErrorLevel {
+			fmt.Fprintf(warnOutput, "zlog: warning: error route %s (%s-%s) stays empty until the error level is lowered from %s\n",
+				r.file.Path, r.min, r.max, cfg.initialErrorLevel)
+		}
+		if err := errorB.addFile(r.file, levelRange{min: r.min, max: r.max}); err != nil {
+			return fail(err)
+		}
+	}
 
-	var accessConsole zapcore.WriteSyncer
 	if cfg.consoleStdout {
-		accessConsole = zapcore.AddSync(os.Stdout)
+		accessB.addConsole(os.Stdout, nil)
 	}
-	var errorConsole zapcore.WriteSyncer
 	if cfg.consoleStderr {
-		errorConsole = zapcore.AddSync(os.Stderr)
+		errorB.addConsole(os.Stderr, nil)
 	}
+	for _, r := range cfg.accessConsoles {
+		if r.w == nil {
+			return fail(errors.New("zlog: WithAccessConsole: nil writer"))
+		}
+		accessB.addConsole(r.w, levelRange{min: r.min, max: r.max})
+	}
+	for _, r := range cfg.errorConsoles {
+		if r.w == nil {
+			return fail(errors.New("zlog: WithErrorConsole: nil writer"))
+		}
+		errorB.addConsole(r.w, levelRange{min: r.min, max: r.max})
+	}
+	if r := cfg.recorder; r != nil {
+		accessB.add("access:recorder", r.encoder(), staticOutput{&r.access}, nil, zapcore.DebugLevel, cfg.sensitiveHash)
+		errorB.add("error:recorder", r.encoder(), staticOutput{&r.error}, nil, zapcore.DebugLevel, cfg.sensitiveHash)
+	}
+	accessLogger, errorLogger := accessB.core(), errorB.core()
+	if cfg.ipAnonymizer != nil {
+		t := newIPAnonymizer(*cfg.ipAnonymizer).transform
+		accessLogger.transform, errorLogger.transform = t, t
+	}
+	if s := cfg.stamper; s != nil {
+		s.seqKey, s.idKey = cfg.seqKey, cfg.entryIDKey
+	}
+	accessLogger.stamper, errorLogger.stamper = cfg.stamper, cfg.stamper
+	accessLogger.procs, errorLogger.procs = cfg.accessProcs, cfg.errorProcs
+	filters := &FilterSet{}
+	if err := filters.Set(cfg.filters); err != nil {
+		return fail(err)
+	}
+	accessLogger.filter, errorLogger.filter = filters, filters
+	if s := cfg.sampling; s != nil {
+		accessLogger.sampler = zapcore.NewSamplerWithOptions(sampleAll{}, s.tick, s.first, s.thereafter)
+	}
+	accessCore := zapcore.Core(accessLogger)
+	errorCore := zapcore.Core(errorLogger)
 
-	// cores (tee: file + console)
-	accessCore := makeCore(cfg.enc, tee(accessFile, accessConsole), accessLevel)
-	errorCore := makeCore(cfg.enc, tee(errorFile, errorConsole), errorLevel)
-
+	// Fatal entries exit through Exit; options given by the user come last and
+	// may replace the hook
+	accessOpts := append([]zap.Option{zap.WithFatalHook(fatalHook{})}, cfg.zapOpts...)
 	errOpts := append([]zap.Option{
 		zap.AddCaller(),
 		zap.AddStacktrace(zapcore.ErrorLevel),
+		zap.WithFatalHook(fatalHook{}),
 	}, cfg.zapOpts...)
 
-	access := zap.New(accessCore, cfg.zapOpts...)
+	access := zap.New(accessCore, accessOpts...)
 	errorL := zap.New(errorCore, errOpts...)
 
-	return &Pair{
+	closers := append(accessB.closers, errorB.closers...)
+	if cfg.crashOutput {
+		path, err := cfg.crashFile()
+		if err != nil {
+			return fail(err)
+		}
+		if err := ingestCrash(errorLogger, path); err != nil {
+			return fail(err)
+		}
+		capture, err := captureCrashes(path)
+		if err != nil {
+			return fail(err)
+		}
+		closers = append(closers, capture)
+	}
+
+	// Exit flushes the pair while any of its loggers can be reached
+	exit := exits.add(accessLogger, errorLogger)
+	accessLogger.exit, errorLogger.exit = exit, exit
+	p := &Pair{
 		Access:      access,
 		Error:       errorL,
 		AccessLevel: accessLevel,
 		ErrorLevel:  errorLevel,
-	}, nil
+		SinkLevels:  sinkLevels,
+		Filters:     filters,
+		closers:     closers,
+		exit:        exit,
+	}
+	return p, nil
 }